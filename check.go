@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	checkFormatTable  = "table"
+	checkFormatJSON   = "json"
+	checkFormatNDJSON = "ndjson"
+)
+
+// quoteCheckResult is one rule checked against a fresh quote. It is what
+// /api/check and the check command report.
+type quoteCheckResult struct {
+	Symbol  string     `json:"symbol"`
+	RuleKey string     `json:"ruleKey"`
+	Rule    *AlertRule `json:"rule,omitempty"`
+	Price   *float64   `json:"price,omitempty"`
+	Quote   *Quote     `json:"quote,omitempty"`
+	// Triggered reports whether the rule's condition holds at this price.
+	Triggered bool `json:"triggered"`
+	// Latched is the in-alert flag saved in the alert state: the rule has
+	// alerted and not re-armed since.
+	Latched bool `json:"latched"`
+	// Distance is how far the price is from triggering the rule, in percent
+	// (percentage points for change rules); zero while in alert.
+	Distance *float64 `json:"distance,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// checkQuotes fetches every symbol once and checks each of its rules, in
+// symbol order. Trailing stops are measured from the peak in the saved alert
+// state, which also supplies Latched; the state itself is not changed.
+func checkQuotes(ctx context.Context, rules map[string][]AlertRule, state map[string]symbolAlertState, calendar *marketCalendar) []quoteCheckResult {
+	symbols := sortedSymbols(rules)
+	quotes := fetchQuotes(ctx, symbols, getFetchWorkersFromEnv())
+
+	results := make([]quoteCheckResult, 0, len(rules))
+	for _, symbol := range symbols {
+		fetched, ok := quotes[symbol]
+		if !ok {
+			// The lookup was never made, e.g. because ctx was cancelled.
+			fetched.err = ctx.Err()
+			if fetched.err == nil {
+				fetched.err = fmt.Errorf("no quote fetched for %s", symbol)
+			}
+		}
+		for _, rule := range rules[symbol] {
+			result := quoteCheckResult{Symbol: symbol, RuleKey: ruleKey(symbol, rule)}
+			result.Latched = state[result.RuleKey].InAlert
+			if fetched.err != nil {
+				rule := rule
+				result.Rule = &rule
+				result.Error = fetched.err.Error()
+				results = append(results, result)
+				continue
+			}
+
+			quote := fetched.quote
+			quote.Session = calendar.sessionAt(symbol, quote.AsOf)
+			if rule.Direction == directionTrailingStop {
+				rule = resolveTrailingStop(rule, math.Max(state[result.RuleKey].PeakPrice, quote.Price))
+			}
+			price := quote.Price
+			distance := percentDistanceToTrigger(quote, rule)
+			result.Rule = &rule
+			result.Price = &price
+			result.Quote = &quote
+			result.Triggered = shouldSendAlert(quote, rule)
+			result.Distance = &distance
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func validCheckFormat(format string) bool {
+	switch format {
+	case checkFormatTable, checkFormatJSON, checkFormatNDJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeCheckResults prints results as an indented JSON array, one JSON object
+// per line, or an aligned table.
+func writeCheckResults(w io.Writer, format string, results []quoteCheckResult) error {
+	switch format {
+	case checkFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case checkFormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, result := range results {
+			if err := enc.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	case checkFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "RULE\tPRICE\tCONDITION\tSTATUS")
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Fprintf(tw, "%s\t-\t%s\terror: %s\n", result.RuleKey, describeRule(*result.Rule), strings.ReplaceAll(result.Error, "\t", " "))
+				continue
+			}
+			status := fmt.Sprintf("%.2f%% away", *result.Distance)
+			if result.Triggered {
+				status = "TRIGGERED"
+			}
+			if result.Latched {
+				status += " (in alert)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.RuleKey, formatQuoteSummary(*result.Quote), describeRule(*result.Rule), status)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
@@ -0,0 +1,767 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes. Usage errors follow the flag package convention.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+
+	// run --once reports the outcome of its poll instead; errors take
+	// precedence over alerts.
+	exitOnceAlerts = 1
+	exitOnceErrors = 2
+)
+
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) int
+}
+
+var cliCommands = []cliCommand{
+	{"run", "Poll quotes and send notifications (default)", runMonitorCommand},
+	{"web", "Serve the configuration UI alongside the monitor", runWebCommand},
+	{"check", "Fetch every quote once and print each rule's status", runCheckCommand},
+	{"validate", "Check stocks.json and the settings file without polling", runValidateCommand},
+	{"rules", "List, add or remove rules (rules list|add|remove)", runRulesCommand},
+	{"state", "Show or reset saved alert state (state show|reset)", runStateCommand},
+	{"test-notify", "Send a sample alert to every configured channel", runTestNotifyCommand},
+}
+
+// runCLI dispatches to a subcommand and returns the process exit code. The
+// legacy form "stocks-notifier <dir> [--web] [--addr=HOST:PORT]" still works.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return runMonitorCommand(nil, stdout, stderr)
+	}
+
+	switch name := args[0]; {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		printUsage(stdout)
+		return exitOK
+	case strings.HasPrefix(name, "-"):
+		return runMonitorCommand(args, stdout, stderr)
+	}
+
+	for _, command := range cliCommands {
+		if command.name == args[0] {
+			return command.run(args[1:], stdout, stderr)
+		}
+	}
+
+	if fi, err := os.Stat(args[0]); err == nil && fi.IsDir() {
+		return runLegacyCommand(args, stdout, stderr)
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+	printUsage(stderr)
+	return exitUsage
+}
+
+// runLegacyCommand maps "<dir> [--web] [--addr=...]" onto run and web.
+func runLegacyCommand(args []string, stdout, stderr io.Writer) int {
+	translated := []string{"--dir", args[0]}
+	web := false
+	for _, arg := range args[1:] {
+		if arg == "--web" {
+			web = true
+			continue
+		}
+		translated = append(translated, arg)
+	}
+	if web {
+		return runWebCommand(translated, stdout, stderr)
+	}
+	return runMonitorCommand(translated, stdout, stderr)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: stocks-notifier <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, command := range cliCommands {
+		fmt.Fprintf(tw, "  %s\t%s\n", command.name, command.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun \"stocks-notifier <command> --help\" for a command's flags. Every command")
+	fmt.Fprintf(w, "takes --dir, the directory holding stocks.json; it defaults to the working\ndirectory, or %s when only that one has a stocks.json.\n", displayConfigDir())
+	fmt.Fprintln(w, "\nExample stocks.json file:")
+	fmt.Fprintln(w, strings.TrimSpace(`
+{
+  "TSLA": 220,
+  "AAPL": {
+    "threshold": 250,
+    "direction": "above"
+  },
+  "NVDA": {
+    "threshold": -5,
+    "direction": "change_pct_below"
+  }
+}`))
+	fmt.Fprintln(w, "\nCheckout documentation if you need any help:")
+	fmt.Fprintln(w, "- https://blog.vmhatre.com/stocks-notifier/")
+	fmt.Fprintln(w, "- https://github.com/Vedant-Mhatre/stocks-notifier")
+}
+
+// userConfigDir is the per-user directory used when the working directory
+// has no stocks.json, e.g. $XDG_CONFIG_HOME/stocks-notifier on Linux.
+func userConfigDir() string {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(base, "stocks-notifier")
+}
+
+func displayConfigDir() string {
+	if dir := userConfigDir(); dir != "" {
+		return dir
+	}
+	return "the user config directory"
+}
+
+// resolveDir returns the --dir value when set. Otherwise it prefers the
+// working directory, falling back to the user config directory only when
+// that one has a stocks.json and the working directory does not.
+func resolveDir(flagValue string) (string, error) {
+	dir := strings.TrimSpace(flagValue)
+	if dir == "" {
+		dir = "."
+		if _, err := os.Stat(filepath.Join(dir, "stocks.json")); err != nil {
+			if configDir := userConfigDir(); configDir != "" {
+				if _, err := os.Stat(filepath.Join(configDir, "stocks.json")); err == nil {
+					dir = configDir
+				}
+			}
+		}
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("'%s' is not a valid directory", dir)
+	}
+	return dir, nil
+}
+
+// commandFlags is the flag set of one subcommand plus the shared --dir flag.
+type commandFlags struct {
+	*flag.FlagSet
+	dir    *string
+	stderr io.Writer
+}
+
+func newCommandFlags(name, usage, summary string, stderr io.Writer) *commandFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: stocks-notifier %s\n\n%s\n\nFlags:\n", usage, summary)
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "", "directory containing stocks.json (default: working directory or "+displayConfigDir()+")")
+	return &commandFlags{FlagSet: fs, dir: dir, stderr: stderr}
+}
+
+// parse parses flags, which may appear before or after positional
+// arguments, and resolves --dir. It returns the positional arguments, or an
+// exit code when the command should stop: exitOK for --help and exitUsage for
+// bad flags or a bad directory.
+func (f *commandFlags) parse(args []string) (string, []string, int, bool) {
+	var positional []string
+	for {
+		if err := f.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return "", nil, exitOK, false
+			}
+			return "", nil, exitUsage, false
+		}
+		args = f.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	dir, err := resolveDir(*f.dir)
+	if err != nil {
+		fmt.Fprintln(f.stderr, err)
+		return "", nil, exitUsage, false
+	}
+	return dir, positional, exitOK, true
+}
+
+// usageError prints message and the command usage and returns exitUsage.
+func (f *commandFlags) usageError(format string, args ...any) int {
+	fmt.Fprintf(f.stderr, format+"\n\n", args...)
+	f.Usage()
+	return exitUsage
+}
+
+func runMonitorCommand(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("run", "run [--dir DIR] [--once]", "Poll quotes and send notifications until interrupted. With --once, poll a single time and exit 0 if no alert fired, 1 if any did, or 2 on errors.", stderr)
+	once := flags.Bool("once", false, "run one poll cycle and exit")
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+
+	if *once {
+		// A long-running monitor falls back to defaults until the settings file
+		// is fixed; a single cycle would only notify the wrong channels.
+		if _, err := readAppSettings(dir); err != nil {
+			fmt.Fprintf(stderr, "Failed to start: %v\n", err)
+			return exitOnceErrors
+		}
+	}
+	monitor, err := NewMonitor(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to start: %v\n", err)
+		if *once {
+			return exitOnceErrors
+		}
+		return exitFailure
+	}
+	if *once {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return onceExitCode(monitor.RunOnce(ctx))
+	}
+	runUntilSignalled(monitor)
+	return exitOK
+}
+
+func onceExitCode(status monitorStatus) int {
+	switch {
+	case status.ConfigError != "" || len(status.Errors) > 0:
+		return exitOnceErrors
+	case status.AlertsFired > 0:
+		return exitOnceAlerts
+	default:
+		return exitOK
+	}
+}
+
+func runWebCommand(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("web", "web [--dir DIR] [--addr HOST:PORT] [--no-monitor]", "Serve the configuration UI. The monitor runs in the same process unless --no-monitor is set.", stderr)
+	addr := flags.String("addr", "127.0.0.1:8080", "UI bind address")
+	noMonitor := flags.Bool("no-monitor", false, "serve the UI without polling")
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+	if strings.TrimSpace(*addr) == "" {
+		return flags.usageError("--addr cannot be empty")
+	}
+
+	var monitor *Monitor
+	if !*noMonitor {
+		var err error
+		if monitor, err = NewMonitor(dir); err != nil {
+			fmt.Fprintf(stderr, "Failed to start: %v\n", err)
+			return exitFailure
+		}
+	}
+
+	server, err := startWebUI(dir, strings.TrimSpace(*addr), monitor)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to start web UI: %v\n", err)
+		return exitFailure
+	}
+	defer server.Shutdown(context.Background())
+
+	if monitor == nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		return exitOK
+	}
+	runUntilSignalled(monitor)
+	return exitOK
+}
+
+// runUntilSignalled runs the monitor until SIGINT or SIGTERM. SIGHUP reloads
+// the settings and polls immediately.
+func runUntilSignalled(monitor *Monitor) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for range hangup {
+			log.Printf("Received SIGHUP, reloading settings")
+			monitor.Reload()
+		}
+	}()
+
+	monitor.Run(ctx)
+}
+
+// loadCommandSettings reads and validates the settings file and makes it the
+// active settings for one-shot commands.
+func loadCommandSettings(dir string) (AppSettings, error) {
+	settings, err := readAppSettings(dir)
+	if err != nil {
+		return AppSettings{}, err
+	}
+	if _, err := resolveProviderChain(settings.Providers); err != nil {
+		return AppSettings{}, fmt.Errorf("invalid provider chain in settings: %v", err)
+	}
+	if err := validateProviderLimits(settings.ProviderLimits); err != nil {
+		return AppSettings{}, fmt.Errorf("invalid settings: %v", err)
+	}
+	setAppSettings(settings)
+	return settings, nil
+}
+
+func runCheckCommand(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("check", "check [--dir DIR] [--format table|json|ndjson]", "Fetch every quote once and print each rule's status, price and distance to its trigger. Exits 1 if any quote fails.", stderr)
+	format := flags.String("format", checkFormatTable, "output format: table, json or ndjson")
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+	*format = strings.ToLower(strings.TrimSpace(*format))
+	if !validCheckFormat(*format) {
+		return flags.usageError("unsupported format %q (supported: %s, %s, %s)", *format, checkFormatTable, checkFormatJSON, checkFormatNDJSON)
+	}
+
+	settings, err := loadCommandSettings(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	calendar, err := newMarketCalendar(dir, settings.MarketHours)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid market hours settings: %v\n", err)
+		return exitFailure
+	}
+	rules, err := readJSONData(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	state, err := readAlertState(dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to read alert state, trailing stops start from the current price: %v\n", err)
+	}
+
+	results := checkQuotes(context.Background(), rules, state, calendar)
+	if err := writeCheckResults(stdout, *format, results); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+func sortedSymbols(rules map[string][]AlertRule) []string {
+	symbols := make([]string, 0, len(rules))
+	for symbol := range rules {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func runValidateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("validate", "validate [--dir DIR]", "Check stocks.json and the settings file, including notification channels, without polling. Exits 1 if either is invalid.", stderr)
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+
+	code = exitOK
+	if rules, err := readJSONData(dir); err != nil {
+		fmt.Fprintf(stdout, "stocks.json: %v\n", err)
+		code = exitFailure
+	} else {
+		count := 0
+		for _, symbolRules := range rules {
+			count += len(symbolRules)
+		}
+		fmt.Fprintf(stdout, "stocks.json: ok (%d symbols, %d rules)\n", len(rules), count)
+	}
+
+	if settings, err := readAppSettings(dir); err != nil {
+		fmt.Fprintf(stdout, "%s: %v\n", settingsFile, err)
+		code = exitFailure
+	} else if _, err := newMonitorConfig(dir, settings); err != nil {
+		fmt.Fprintf(stdout, "%s: %v\n", settingsFile, err)
+		code = exitFailure
+	} else {
+		fmt.Fprintf(stdout, "%s: ok\n", settingsFile)
+	}
+	return code
+}
+
+func runRulesCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(stderr, "Usage: stocks-notifier rules list|add|remove [flags]")
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "--help" || args[0] == "-help") {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "list":
+		return runRulesList(args[1:], stdout, stderr)
+	case "add":
+		return runRulesAdd(args[1:], stdout, stderr)
+	case "remove":
+		return runRulesRemove(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown rules command %q\nUsage: stocks-notifier rules list|add|remove [flags]\n", args[0])
+		return exitUsage
+	}
+}
+
+func runRulesList(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("rules list", "rules list [--dir DIR]", "Print every rule in stocks.json.", stderr)
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+
+	rules, err := readJSONData(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tCONDITION\tSEVERITY\tSESSION\tQUIET HOURS")
+	for _, symbol := range sortedSymbols(rules) {
+		for _, rule := range rules[symbol] {
+			quietHours := "held"
+			if rule.bypassesQuietHours() {
+				quietHours = "bypass"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", ruleKey(symbol, rule), describeRule(rule), orDefault(rule.Severity, severityDefault), orDefault(rule.Session, sessionAlways), quietHours)
+		}
+	}
+	tw.Flush()
+	return exitOK
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func runRulesAdd(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("rules add", "rules add SYMBOL [flags]", "Add a rule to stocks.json, creating the file if needed.", stderr)
+	var rule AlertRule
+	flags.StringVar(&rule.ID, "id", "", "rule id (default: derived from the direction and values when the symbol has several rules)")
+	flags.Float64Var(&rule.Threshold, "threshold", 0, "price, or day change percent for change_pct rules")
+	flags.StringVar(&rule.Direction, "direction", directionBelow, "below, above, change_pct_below, change_pct_above, between, outside or trailing_stop")
+	flags.Float64Var(&rule.Lower, "lower", 0, "lower bound for between/outside rules")
+	flags.Float64Var(&rule.Upper, "upper", 0, "upper bound for between/outside rules")
+	flags.Float64Var(&rule.RearmPercent, "rearm", 0, "re-arm percent (default: the global setting)")
+	flags.Float64Var(&rule.TrailPercent, "trail", 0, "trail percent for trailing_stop rules")
+	flags.StringVar(&rule.Severity, "severity", "", "low, default, high or urgent")
+	flags.StringVar(&rule.Session, "session", "", "regular, extended or always")
+	flags.BoolVar(&rule.BypassQuietHours, "bypass-quiet-hours", false, "send alerts immediately during quiet hours")
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) != 1 {
+		return flags.usageError("expected exactly one symbol")
+	}
+	symbol := strings.ToUpper(strings.TrimSpace(positional[0]))
+
+	if err := rule.normalize(); err != nil {
+		return flags.usageError("invalid rule: %v", err)
+	}
+	if rule.RearmPercent < 0 {
+		return flags.usageError("invalid re-arm percent")
+	}
+	if err := validateRuleValues(rule); err != nil {
+		return flags.usageError("%v", err)
+	}
+
+	rules, err := readJSONData(dir)
+	if err != nil {
+		if _, statErr := os.Stat(filepath.Join(dir, "stocks.json")); !os.IsNotExist(statErr) {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		rules = map[string][]AlertRule{}
+	}
+
+	symbolRules := append(rules[symbol], rule)
+	if err := assignRuleIDs(symbolRules); err != nil {
+		fmt.Fprintf(stderr, "invalid rules for %s: %v\n", symbol, err)
+		return exitFailure
+	}
+	rules[symbol] = symbolRules
+	if err := writeJSONData(dir, rules); err != nil {
+		fmt.Fprintf(stderr, "failed writing stocks.json: %v\n", err)
+		return exitFailure
+	}
+
+	added := symbolRules[len(symbolRules)-1]
+	fmt.Fprintf(stdout, "Added %s: %s\n", ruleKey(symbol, added), describeRule(added))
+	return exitOK
+}
+
+func runRulesRemove(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("rules remove", "rules remove SYMBOL[#ID] [--dir DIR]", "Remove one rule (SYMBOL#ID) or every rule of a symbol from stocks.json.", stderr)
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) != 1 {
+		return flags.usageError("expected exactly one SYMBOL or SYMBOL#ID")
+	}
+
+	rules, err := readJSONData(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	symbol, id, byID := strings.Cut(strings.TrimSpace(positional[0]), "#")
+	symbol = strings.ToUpper(symbol)
+	symbolRules, found := rules[symbol]
+	if found && byID {
+		found = false
+		kept := symbolRules[:0]
+		for _, rule := range symbolRules {
+			// A lone unnamed rule has no id yet, but it still answers to
+			// the one it would be derived as.
+			if rule.ID == id || (rule.ID == "" && ruleContentID(rule) == id) {
+				found = true
+				continue
+			}
+			kept = append(kept, rule)
+		}
+		symbolRules = kept
+	} else {
+		symbolRules = nil
+	}
+	if !found {
+		fmt.Fprintf(stderr, "no rule %s in stocks.json\n", positional[0])
+		return exitFailure
+	}
+
+	if len(symbolRules) == 0 {
+		delete(rules, symbol)
+	} else {
+		rules[symbol] = symbolRules
+	}
+	if err := writeJSONData(dir, rules); err != nil {
+		fmt.Fprintf(stderr, "failed writing stocks.json: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Removed %s\n", positional[0])
+	return exitOK
+}
+
+func runStateCommand(args []string, stdout, stderr io.Writer) int {
+	const usage = "Usage: stocks-notifier state show|reset [flags]"
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(stderr, usage)
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "--help" || args[0] == "-help") {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "show":
+		return runStateShow(args[1:], stdout, stderr)
+	case "reset":
+		return runStateReset(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown state command %q\n%s\n", args[0], usage)
+		return exitUsage
+	}
+}
+
+func runStateShow(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("state show", "state show [--dir DIR]", "Print the saved alert state and ongoing errors.", stderr)
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+
+	state, err := readAlertState(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	errorEntries, err := readErrorState(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tSTATUS\tLAST NOTIFIED\tDETAIL")
+	for _, key := range keys {
+		current := state[key]
+		status := "armed"
+		if current.InAlert {
+			status = "in alert"
+		}
+		lastNotified := "-"
+		if current.LastNotifiedUnix > 0 {
+			lastNotified = time.Unix(current.LastNotifiedUnix, 0).Format(time.RFC1123)
+		}
+		var detail []string
+		if current.PeakPrice > 0 {
+			detail = append(detail, fmt.Sprintf("peak %.2f", current.PeakPrice))
+		}
+		if current.Suppressed != nil {
+			detail = append(detail, "held (quiet hours)")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, status, lastNotified, strings.Join(detail, ", "))
+	}
+	tw.Flush()
+
+	if len(errorEntries) > 0 {
+		entries := make([]errorStateEntry, 0, len(errorEntries))
+		for _, entry := range errorEntries {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Symbol+entries[i].Class < entries[j].Symbol+entries[j].Class })
+
+		fmt.Fprintln(stdout, "\nOngoing errors:")
+		for _, entry := range entries {
+			symbol := orDefault(entry.Symbol, "stocks.json")
+			fmt.Fprintf(stdout, "  %s (%s) since %s: %s\n", symbol, entry.Class, time.Unix(entry.FirstSeenUnix, 0).Format(time.RFC1123), entry.Message)
+		}
+	}
+	return exitOK
+}
+
+func runStateReset(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("state reset", "state reset [--dir DIR] [RULE...]", "Clear the saved state of the given rules (SYMBOL or SYMBOL#ID), or of every rule and error when none are given. Stop the monitor first; it saves its own copy of the state every poll.", stderr)
+	dir, keys, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+
+	if len(keys) == 0 {
+		for _, name := range []string{alertStateFile, errorStateFile} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(stderr, "failed removing %s: %v\n", name, err)
+				return exitFailure
+			}
+		}
+		fmt.Fprintln(stdout, "Cleared all alert and error state")
+		return exitOK
+	}
+
+	state, err := readAlertState(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	for _, key := range keys {
+		symbol, id, hasID := strings.Cut(key, "#")
+		key = strings.ToUpper(symbol)
+		if hasID {
+			key += "#" + id
+		}
+		if _, ok := state[key]; !ok {
+			fmt.Fprintf(stderr, "no saved state for %s\n", key)
+			return exitFailure
+		}
+		delete(state, key)
+	}
+	if err := writeAlertState(dir, state); err != nil {
+		fmt.Fprintf(stderr, "failed writing alert state: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Cleared state for %s\n", strings.Join(keys, ", "))
+	return exitOK
+}
+
+func runTestNotifyCommand(args []string, stdout, stderr io.Writer) int {
+	flags := newCommandFlags("test-notify", "test-notify [--dir DIR]", "Send a sample alert to every configured channel and report each result. Exits 1 if any channel fails.", stderr)
+	dir, positional, code, ok := flags.parse(args)
+	if !ok {
+		return code
+	}
+	if len(positional) > 0 {
+		return flags.usageError("unexpected argument %q", positional[0])
+	}
+
+	settings, err := readAppSettings(dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	notifiers, err := buildNotifiers(dir, settings.Channels, settings.Templates)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid notification channels in settings: %v\n", err)
+		return exitFailure
+	}
+
+	// Channels and templates format the sample like a real alert; only the
+	// message prefix shows it is a test.
+	n := sampleAlertNotification()
+	n.Kind = notificationTest
+	n.Time = time.Now()
+	n = markTestNotification(n)
+
+	code = exitOK
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(n); err != nil {
+			fmt.Fprintf(stdout, "%s: failed: %v\n", notifier.Name(), err)
+			code = exitFailure
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: sent\n", notifier.Name())
+	}
+	return code
+}
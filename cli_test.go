@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runCLIForTest(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := runCLI(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIUsageErrorsAndHelp(t *testing.T) {
+	if code, stdout, _ := runCLIForTest(t, "--help"); code != exitOK || !strings.Contains(stdout, "test-notify") {
+		t.Fatalf("expected top-level help, got %d: %s", code, stdout)
+	}
+	if code, _, stderr := runCLIForTest(t, "rules", "add", "--help"); code != exitOK || !strings.Contains(stderr, "-threshold") {
+		t.Fatalf("expected rules add help, got %d: %s", code, stderr)
+	}
+	if code, _, _ := runCLIForTest(t, "frobnicate"); code != exitUsage {
+		t.Fatalf("expected unknown command to be a usage error, got %d", code)
+	}
+	if code, _, _ := runCLIForTest(t, "validate", "--bogus"); code != exitUsage {
+		t.Fatalf("expected unknown flag to be a usage error, got %d", code)
+	}
+	if code, _, stderr := runCLIForTest(t, "validate", "--dir", filepath.Join(t.TempDir(), "missing")); code != exitUsage || !strings.Contains(stderr, "not a valid directory") {
+		t.Fatalf("expected missing directory to be a usage error, got %d: %s", code, stderr)
+	}
+}
+
+func TestCLIRulesAddListRemove(t *testing.T) {
+	dir := t.TempDir()
+
+	if code, stdout, stderr := runCLIForTest(t, "rules", "add", "aapl", "--dir", dir, "--threshold", "150", "--direction", "above"); code != exitOK || !strings.Contains(stdout, "Added AAPL: above 150.00") {
+		t.Fatalf("rules add failed (%d): %s%s", code, stdout, stderr)
+	}
+	if code, _, stderr := runCLIForTest(t, "rules", "add", "AAPL", "--dir", dir, "--id", "dip", "--threshold", "120", "--severity", "urgent"); code != exitOK {
+		t.Fatalf("second rules add failed (%d): %s", code, stderr)
+	}
+	if code, _, _ := runCLIForTest(t, "rules", "add", "AAPL", "--dir", dir, "--direction", "sideways"); code != exitUsage {
+		t.Fatalf("expected an invalid direction to be a usage error, got %d", code)
+	}
+
+	code, stdout, _ := runCLIForTest(t, "rules", "list", "--dir", dir)
+	if code != exitOK || !strings.Contains(stdout, "AAPL#above-150") || !strings.Contains(stdout, "AAPL#dip") || !strings.Contains(stdout, "urgent") {
+		t.Fatalf("unexpected rules list (%d): %s", code, stdout)
+	}
+
+	if code, _, stderr := runCLIForTest(t, "rules", "remove", "AAPL#above-150", "--dir", dir); code != exitOK {
+		t.Fatalf("rules remove failed (%d): %s", code, stderr)
+	}
+	if code, _, _ := runCLIForTest(t, "rules", "remove", "MSFT", "--dir", dir); code != exitFailure {
+		t.Fatalf("expected removing a missing rule to fail, got %d", code)
+	}
+	rules, err := readJSONData(dir)
+	if err != nil || len(rules["AAPL"]) != 1 || rules["AAPL"][0].ID != "dip" {
+		t.Fatalf("unexpected rules after remove: %+v (%v)", rules, err)
+	}
+
+	if code, _, stderr := runCLIForTest(t, "rules", "add", "MSFT", "--dir", dir, "--threshold", "300"); code != exitOK {
+		t.Fatalf("rules add failed (%d): %s", code, stderr)
+	}
+	if code, _, stderr := runCLIForTest(t, "rules", "remove", "MSFT#below-300", "--dir", dir); code != exitOK {
+		t.Fatalf("expected a lone rule to be removable by its derived id (%d): %s", code, stderr)
+	}
+	if rules, _ := readJSONData(dir); len(rules["MSFT"]) != 0 {
+		t.Fatalf("expected MSFT to be removed: %+v", rules)
+	}
+}
+
+func TestCLIStateShowAndReset(t *testing.T) {
+	dir := t.TempDir()
+	state := map[string]symbolAlertState{"AAPL": {InAlert: true, LastNotifiedUnix: 1_700_000_000}, "NVDA#stop": {PeakPrice: 900}}
+	if err := writeAlertState(dir, state); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+
+	code, stdout, _ := runCLIForTest(t, "state", "show", "--dir", dir)
+	if code != exitOK || !strings.Contains(stdout, "in alert") || !strings.Contains(stdout, "peak 900.00") {
+		t.Fatalf("unexpected state show (%d): %s", code, stdout)
+	}
+
+	if code, _, _ := runCLIForTest(t, "state", "reset", "aapl", "--dir", dir); code != exitOK {
+		t.Fatalf("state reset failed: %d", code)
+	}
+	if saved, _ := readAlertState(dir); len(saved) != 1 || saved["NVDA#stop"].PeakPrice != 900 {
+		t.Fatalf("expected only AAPL to be cleared: %+v", saved)
+	}
+	if code, _, _ := runCLIForTest(t, "state", "reset", "--dir", dir); code != exitOK {
+		t.Fatalf("state reset all failed: %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, alertStateFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected the state file to be removed: %v", err)
+	}
+}
+
+func TestCLIValidateCheckAndTestNotify(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, recorder := newTestMonitor(t, `{"AAA": 150, "BBB": 50}`, AppSettings{})
+	dir := monitor.dir
+
+	if code, stdout, _ := runCLIForTest(t, "validate", "--dir", dir); code != exitOK || !strings.Contains(stdout, "2 symbols, 2 rules") {
+		t.Fatalf("expected valid config (%d): %s", code, stdout)
+	}
+
+	code, stdout, _ := runCLIForTest(t, "check", "--dir", dir)
+	if code != exitOK || !strings.Contains(stdout, "TRIGGERED") || !strings.Contains(stdout, "BBB") {
+		t.Fatalf("unexpected check output (%d): %s", code, stdout)
+	}
+
+	if code, stdout, _ := runCLIForTest(t, "test-notify", "--dir", dir); code != exitOK || !strings.Contains(stdout, "recorder: sent") || len(recorder.sent) != 1 {
+		t.Fatalf("unexpected test-notify result (%d): %s", code, stdout)
+	}
+	if sent := recorder.sent[0]; sent.Kind != notificationTest || hasCustomTitle(sent) || !strings.HasPrefix(sent.Message, testNotificationPrefix) {
+		t.Fatalf("expected a marked test alert with the built-in title, got: %+v", sent)
+	}
+	recorder.err = errors.New("channel down")
+	if code, _, _ := runCLIForTest(t, "test-notify", "--dir", dir); code != exitFailure {
+		t.Fatalf("expected a failing channel to exit 1, got %d", code)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "stocks.json"), []byte(`{"AAA": {"direction": "sideways"}}`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	if code, stdout, _ := runCLIForTest(t, "validate", "--dir", dir); code != exitFailure || !strings.Contains(stdout, "sideways") {
+		t.Fatalf("expected invalid rules to fail validation (%d): %s", code, stdout)
+	}
+}
+
+func TestCLICheckFormats(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, _ := newTestMonitor(t, `{"AAA": [{"id": "dip", "threshold": 150}, {"threshold": 80}], "BBB": {"direction": "trailing_stop", "trailPercent": 10}}`, AppSettings{})
+	dir := monitor.dir
+	if err := writeAlertState(dir, map[string]symbolAlertState{"AAA#dip": {InAlert: true}, "BBB": {PeakPrice: 120}}); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+
+	code, stdout, _ := runCLIForTest(t, "check", "--dir", dir, "--format", "json")
+	var results []quoteCheckResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil || code != exitOK {
+		t.Fatalf("expected a JSON array (%d, %v): %s", code, err, stdout)
+	}
+	if len(results) != 3 || results[0].RuleKey != "AAA#dip" || !results[0].Triggered || !results[0].Latched || *results[0].Price != 100 {
+		t.Fatalf("unexpected results: %s", stdout)
+	}
+	if results[1].Triggered || results[1].Latched || *results[1].Distance != 25 {
+		t.Fatalf("expected the second AAA rule to be 25%% away: %+v", results[1])
+	}
+	// The trailing stop is measured from the saved peak: 120 less 10% is 108.
+	// It triggers now but has not alerted yet, so it is not latched.
+	if !results[2].Triggered || results[2].Latched || results[2].Rule.Threshold != 108 {
+		t.Fatalf("expected the trailing stop from the saved peak: %+v", results[2])
+	}
+
+	code, stdout, _ = runCLIForTest(t, "check", "--dir", dir, "--format", "ndjson")
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if code != exitOK || len(lines) != 3 {
+		t.Fatalf("expected one line per rule (%d): %s", code, stdout)
+	}
+	var line quoteCheckResult
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil || line.Symbol != "AAA" || line.Quote == nil {
+		t.Fatalf("unexpected NDJSON line (%v): %s", err, lines[1])
+	}
+
+	code, stdout, _ = runCLIForTest(t, "check", "--dir", dir)
+	if code != exitOK || !strings.Contains(stdout, "TRIGGERED (in alert)") {
+		t.Fatalf("expected the table to show the latched rule (%d): %s", code, stdout)
+	}
+
+	if code, _, stderr := runCLIForTest(t, "check", "--dir", dir, "--format", "xml"); code != exitUsage || !strings.Contains(stderr, "xml") {
+		t.Fatalf("expected an unknown format to be a usage error (%d): %s", code, stderr)
+	}
+}
+
+func TestCheckQuotesReportsMissingQuotesAsErrors(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := checkQuotes(ctx, map[string][]AlertRule{"AAPL": {{Threshold: 150, Direction: directionBelow}}}, nil, nil)
+	if len(results) != 1 || results[0].Error == "" || results[0].Price != nil || results[0].Triggered {
+		t.Fatalf("expected an error record for the unfetched quote, got: %+v", results)
+	}
+}
+
+func TestCLITestNotifyIsMarkedAndNeverQueued(t *testing.T) {
+	var healthy atomic.Bool
+	var kinds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload alertPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		kinds = append(kinds, payload.Kind)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	settings := AppSettings{Channels: []ChannelSettings{{Type: channelWebhook, Webhook: &WebhookConfig{URL: server.URL}}}}
+	if err := writeAppSettings(dir, settings); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+
+	if code, stdout, _ := runCLIForTest(t, "test-notify", "--dir", dir); code != exitFailure || !strings.Contains(stdout, "failed") {
+		t.Fatalf("expected the failing endpoint to be reported (%d): %s", code, stdout)
+	}
+	if entries, err := readOutbox(filepath.Join(dir, webhookOutboxFile)); err != nil || len(entries) != 0 {
+		t.Fatalf("expected the test notification not to be queued, got %+v (err=%v)", entries, err)
+	}
+
+	healthy.Store(true)
+	if code, _, _ := runCLIForTest(t, "test-notify", "--dir", dir); code != exitOK || fmt.Sprint(kinds) != "[test]" {
+		t.Fatalf("expected one delivery of kind test (%d), got %v", code, kinds)
+	}
+}
+
+func TestResolveDirPrefersWorkingDirectory(t *testing.T) {
+	work, config := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", config)
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+
+	if dir, err := resolveDir(""); err != nil || dir != "." {
+		t.Fatalf("expected the working directory without any stocks.json, got %q (%v)", dir, err)
+	}
+
+	configDir := userConfigDir()
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "stocks.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	if dir, _ := resolveDir(""); dir != configDir {
+		t.Fatalf("expected the user config directory, got %q", dir)
+	}
+
+	if err := os.WriteFile(filepath.Join(work, "stocks.json"), []byte(`{}`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	if dir, _ := resolveDir(""); dir != "." {
+		t.Fatalf("expected the working directory to win, got %q", dir)
+	}
+}
+
+func TestCLIRunOnceExitCodes(t *testing.T) {
+	fake := &fakeQuoteProvider{name: "fake", price: 100}
+	useFakeProviders(t, fake)
+	monitor, recorder := newTestMonitor(t, `{"AAA": 150, "BBB": 150, "CCC": 50}`, AppSettings{Digest: &DigestSettings{Enabled: true, Window: "1h"}})
+	dir := monitor.dir
+
+	if code, _, stderr := runCLIForTest(t, "run", "--once", "--dir", dir); code != exitOnceAlerts {
+		t.Fatalf("expected exit 1 when alerts fire, got %d: %s", code, stderr)
+	}
+	if len(recorder.sent) != 1 || recorder.sent[0].Kind != notificationDigest {
+		t.Fatalf("expected the digest to be sent before exiting, got: %+v", recorder.sent)
+	}
+
+	if code, _, _ := runCLIForTest(t, dir, "--once"); code != exitOK {
+		t.Fatalf("expected exit 0 once the alerts are latched in the state file, got %d", code)
+	}
+
+	state, err := readAlertState(dir)
+	if err != nil {
+		t.Fatalf("read state: %v", err)
+	}
+	holdAlert("AAA", Notification{Symbol: "AAA", Message: "AAA below 150", Time: time.Now()}, state)
+	if err := writeAlertState(dir, state); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+	if code, _, _ := runCLIForTest(t, "run", "--once", "--dir", dir); code != exitOnceAlerts {
+		t.Fatalf("expected exit 1 when held alerts are released, got %d", code)
+	}
+
+	fake.err = errors.New("provider down")
+	if code, _, _ := runCLIForTest(t, "run", "--once", "--dir", dir); code != exitOnceErrors {
+		t.Fatalf("expected exit 2 on quote errors, got %d", code)
+	}
+
+	fake.err = nil
+	sent := len(recorder.sent)
+	if err := os.WriteFile(filepath.Join(dir, settingsFile), []byte(`{"channels": [`), 0o644); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	if code, _, stderr := runCLIForTest(t, "run", "--once", "--dir", dir); code != exitOnceErrors || !strings.Contains(stderr, "settings") {
+		t.Fatalf("expected exit 2 on an unreadable settings file, got %d: %s", code, stderr)
+	}
+	if len(recorder.sent) != sent {
+		t.Fatalf("expected no notifications with unreadable settings, got: %+v", recorder.sent[sent:])
+	}
+}
+
+func TestCLIRunOnceExitsZeroWhileAlertsAreHeld(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	always := &QuietHoursSettings{Timezone: "UTC", Windows: []QuietWindow{{Start: "00:00", End: "23:59"}, {Start: "23:59", End: "00:00"}}}
+	monitor, recorder := newTestMonitor(t, `{"AAA": 150}`, AppSettings{QuietHours: always})
+
+	if code, _, stderr := runCLIForTest(t, "run", "--once", "--dir", monitor.dir); code != exitOK {
+		t.Fatalf("expected exit 0 while the alert is held, got %d: %s", code, stderr)
+	}
+	if len(recorder.sent) != 0 {
+		t.Fatalf("expected the alert to be held, got: %+v", recorder.sent)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const notificationDigest = "digest"
+
+// DigestSettings batches the alerts of a poll cycle into one notification per
+// channel.
+type DigestSettings struct {
+	Enabled bool `json:"enabled"`
+	// Window keeps collecting alerts across poll cycles until the oldest one
+	// is this old, e.g. "15m". Empty sends one digest per poll cycle.
+	Window string `json:"window,omitempty"`
+}
+
+// alertDigest collects alerts until they are due to be sent together. Pending
+// alerts live in memory only.
+type alertDigest struct {
+	window  time.Duration
+	pending []Notification
+}
+
+// newAlertDigest validates the settings. It returns nil when digest mode is
+// off.
+func newAlertDigest(settings *DigestSettings) (*alertDigest, error) {
+	if settings == nil || !settings.Enabled {
+		return nil, nil
+	}
+	digest := &alertDigest{}
+	if raw := strings.TrimSpace(settings.Window); raw != "" {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window < 0 {
+			return nil, fmt.Errorf("invalid digest window %q", settings.Window)
+		}
+		digest.window = window
+	}
+	return digest, nil
+}
+
+func (d *alertDigest) add(n Notification) {
+	d.pending = append(d.pending, n)
+}
+
+// dueAt reports when the pending alerts should be sent.
+func (d *alertDigest) dueAt() (time.Time, bool) {
+	if d == nil || len(d.pending) == 0 {
+		return time.Time{}, false
+	}
+	return d.pending[0].Time.Add(d.window), true
+}
+
+// flush returns the pending alerts as one notification once they are due. A
+// single pending alert is returned unchanged so it keeps its full formatting.
+func (d *alertDigest) flush(now time.Time) (Notification, bool) {
+	due, ok := d.dueAt()
+	if !ok || now.Before(due) {
+		return Notification{}, false
+	}
+	return d.drain(now)
+}
+
+// drain returns the pending alerts regardless of the window, e.g. when the
+// notifier shuts down.
+func (d *alertDigest) drain(now time.Time) (Notification, bool) {
+	if d == nil || len(d.pending) == 0 {
+		return Notification{}, false
+	}
+
+	alerts := d.pending
+	d.pending = nil
+	if len(alerts) == 1 {
+		return alerts[0], true
+	}
+	return newDigestNotification(alerts, now), true
+}
+
+// alertCount reports how many alerts a notification from flush or drain
+// carries.
+func alertCount(n Notification) int {
+	if n.Kind == notificationDigest {
+		return len(n.Alerts)
+	}
+	return 1
+}
+
+func newDigestNotification(alerts []Notification, now time.Time) Notification {
+	return Notification{
+		Kind:    notificationDigest,
+		Title:   notificationTitle,
+		Message: digestMessage(alerts),
+		Alerts:  alerts,
+		Time:    now,
+	}
+}
+
+func digestMessage(alerts []Notification) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "%d stock alerts:", len(alerts))
+	for _, alert := range alerts {
+		message.WriteString("\n- " + alert.Message)
+	}
+	return message.String()
+}
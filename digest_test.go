@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func digestTestAlert(symbol string, price, threshold float64, severity string, at time.Time) Notification {
+	rule := AlertRule{Threshold: threshold, Direction: directionBelow, Severity: severity}
+	return newAlertNotification(symbol, rule, Quote{Symbol: symbol, Price: price, Source: providerStockpricesDev}, at)
+}
+
+func TestDigestFlushesOncePerCycle(t *testing.T) {
+	digest, err := newAlertDigest(&DigestSettings{Enabled: true})
+	if err != nil {
+		t.Fatalf("newAlertDigest failed: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0).UTC()
+
+	if _, ok := digest.flush(now); ok {
+		t.Fatalf("expected nothing to flush without alerts")
+	}
+
+	digest.add(digestTestAlert("AAPL", 179, 180, "", now))
+	single, ok := digest.flush(now)
+	if !ok || single.Kind != notificationAlert || single.Symbol != "AAPL" {
+		t.Fatalf("expected a lone alert to be sent unchanged, got: %+v", single)
+	}
+
+	digest.add(digestTestAlert("AAPL", 179, 180, "", now))
+	digest.add(digestTestAlert("MSFT", 399, 400, severityUrgent, now))
+	grouped, ok := digest.flush(now)
+	if !ok || grouped.Kind != notificationDigest || len(grouped.Alerts) != 2 {
+		t.Fatalf("expected a digest of two alerts, got: %+v", grouped)
+	}
+	if !strings.HasPrefix(grouped.Message, "2 stock alerts:\n- Price of stock AAPL") {
+		t.Fatalf("unexpected digest message: %q", grouped.Message)
+	}
+	if got := notificationSeverity(grouped); got != severityUrgent {
+		t.Fatalf("expected digest to take the highest severity, got %q", got)
+	}
+	if _, ok := digest.dueAt(); ok {
+		t.Fatalf("expected flush to clear pending alerts")
+	}
+}
+
+func TestDigestWindowSpansCycles(t *testing.T) {
+	digest, err := newAlertDigest(&DigestSettings{Enabled: true, Window: "15m"})
+	if err != nil {
+		t.Fatalf("newAlertDigest failed: %v", err)
+	}
+	start := time.Unix(1_700_000_000, 0).UTC()
+
+	digest.add(digestTestAlert("AAPL", 179, 180, "", start))
+	if _, ok := digest.flush(start.Add(5 * time.Minute)); ok {
+		t.Fatalf("expected digest to wait for the window")
+	}
+	digest.add(digestTestAlert("MSFT", 399, 400, "", start.Add(10*time.Minute)))
+
+	due, _ := digest.dueAt()
+	if !due.Equal(start.Add(15 * time.Minute)) {
+		t.Fatalf("expected digest due 15m after the first alert, got %v", due)
+	}
+	grouped, ok := digest.flush(due)
+	if !ok || len(grouped.Alerts) != 2 {
+		t.Fatalf("expected both alerts once the window ended, got: %+v", grouped)
+	}
+}
+
+func TestDigestSettingsValidation(t *testing.T) {
+	if digest, err := newAlertDigest(&DigestSettings{Window: "15m"}); digest != nil || err != nil {
+		t.Fatalf("expected disabled digest to be nil, got %v (%v)", digest, err)
+	}
+	if _, err := newAlertDigest(&DigestSettings{Enabled: true, Window: "soon"}); err == nil {
+		t.Fatalf("expected invalid window to be rejected")
+	}
+}
+
+func TestDigestRendersAlertTemplatesAndPayload(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0).UTC()
+	digest := newDigestNotification([]Notification{
+		digestTestAlert("AAPL", 179, 180, "", now),
+		digestTestAlert("MSFT", 399, 400, "", now),
+	}, now)
+
+	compiled, err := compileTemplates(MessageTemplates{AlertBody: "{{.Symbol}} {{fixed 0 .Quote.Price}}"})
+	if err != nil {
+		t.Fatalf("compileTemplates failed: %v", err)
+	}
+	rendered, err := compiled.render(digest)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if rendered.Message != "2 stock alerts:\n- AAPL 179\n- MSFT 399" {
+		t.Fatalf("unexpected rendered digest: %q", rendered.Message)
+	}
+
+	payload := buildAlertPayload(rendered)
+	if payload.Kind != notificationDigest || len(payload.Alerts) != 2 || payload.Alerts[1].Symbol != "MSFT" {
+		t.Fatalf("unexpected digest payload: %+v", payload)
+	}
+}
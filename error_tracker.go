@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	notificationRecovered = "recovered"
+
+	errorClassConfig        = "config"
+	errorClassProviderDown  = "provider_down"
+	errorClassUnknownSymbol = "unknown_symbol"
+
+	errorStateFile      = ".stocks-notifier-errors.json"
+	defaultErrorBackoff = time.Hour
+)
+
+var (
+	errConfig        = errors.New("configuration error")
+	errProviderDown  = errors.New("quote provider unavailable")
+	errUnknownSymbol = errors.New("unknown symbol")
+)
+
+// classifiedError tags an error with one of the sentinel classes above
+// without changing its message.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string        { return e.err.Error() }
+func (e *classifiedError) Unwrap() error        { return e.err }
+func (e *classifiedError) Is(target error) bool { return target == e.class }
+
+func classify(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// errorClass names the class of err. Unclassified errors come from failed
+// quote lookups and are treated as the provider being down.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, errConfig):
+		return errorClassConfig
+	case errors.Is(err, errUnknownSymbol):
+		return errorClassUnknownSymbol
+	default:
+		return errorClassProviderDown
+	}
+}
+
+// errorStateEntry tracks one ongoing error for a symbol and class. The empty
+// symbol is used for errors that are not tied to a symbol, such as an
+// unreadable stocks.json.
+type errorStateEntry struct {
+	Symbol           string `json:"symbol"`
+	Class            string `json:"class"`
+	Message          string `json:"message"`
+	FirstSeenUnix    int64  `json:"first_seen_unix"`
+	LastNotifiedUnix int64  `json:"last_notified_unix"`
+	// Suppressed counts repeats since the last notification.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// errorTracker deduplicates error notifications per symbol and class and
+// reports when a symbol recovers.
+type errorTracker struct {
+	backoff time.Duration
+	entries map[string]errorStateEntry
+}
+
+func newErrorTracker(backoff time.Duration, entries map[string]errorStateEntry) *errorTracker {
+	if entries == nil {
+		entries = map[string]errorStateEntry{}
+	}
+	return &errorTracker{backoff: backoff, entries: entries}
+}
+
+func getErrorBackoffFromEnv() time.Duration {
+	return getDurationWithSetting("STOCKS_NOTIFIER_ERROR_BACKOFF", currentSettings().ErrorBackoff, defaultErrorBackoff)
+}
+
+func errorStateKey(symbol, class string) string {
+	return symbol + "|" + class
+}
+
+// report records err and returns the notification to send, if any. The first
+// occurrence is always sent; repeats are sent again only after the backoff.
+func (t *errorTracker) report(symbol string, err error, now time.Time) (Notification, bool) {
+	class := errorClass(err)
+	key := errorStateKey(symbol, class)
+	entry, seen := t.entries[key]
+	entry.Symbol = symbol
+	entry.Class = class
+	entry.Message = err.Error()
+
+	if seen && now.Sub(time.Unix(entry.LastNotifiedUnix, 0)) < t.backoff {
+		entry.Suppressed++
+		t.entries[key] = entry
+		return Notification{}, false
+	}
+
+	if !seen {
+		entry.FirstSeenUnix = now.Unix()
+	}
+	repeats := entry.Suppressed
+	entry.LastNotifiedUnix = now.Unix()
+	entry.Suppressed = 0
+	t.entries[key] = entry
+
+	n := newErrorNotification(symbol, err, now)
+	n.ErrorClass = class
+	if seen {
+		n.Message = fmt.Sprintf("%s (ongoing since %s, %d repeats not notified)", n.Message, time.Unix(entry.FirstSeenUnix, 0).Format(time.RFC1123), repeats)
+	}
+	return n, true
+}
+
+// resolve clears the errors recorded for symbol and returns a recovery
+// notification when there were any.
+func (t *errorTracker) resolve(symbol string, now time.Time) (Notification, bool) {
+	var cleared []errorStateEntry
+	for key, entry := range t.entries {
+		if entry.Symbol == symbol {
+			cleared = append(cleared, entry)
+			delete(t.entries, key)
+		}
+	}
+	if len(cleared) == 0 {
+		return Notification{}, false
+	}
+	sort.Slice(cleared, func(i, j int) bool { return cleared[i].FirstSeenUnix < cleared[j].FirstSeenUnix })
+
+	first := cleared[0]
+	outage := now.Sub(time.Unix(first.FirstSeenUnix, 0)).Round(time.Second)
+	message := fmt.Sprintf("Recovered: %s is quoting again after %s (%s)", symbol, outage, first.Class)
+	if symbol == "" {
+		message = fmt.Sprintf("Recovered: configuration is readable again after %s", outage)
+	}
+	return Notification{
+		Kind:       notificationRecovered,
+		Title:      notificationTitle,
+		Message:    message,
+		Symbol:     symbol,
+		ErrorClass: first.Class,
+		Time:       now,
+	}, true
+}
+
+// prune drops errors for symbols that are no longer configured.
+func (t *errorTracker) prune(rules map[string][]AlertRule) {
+	for key, entry := range t.entries {
+		if _, ok := rules[entry.Symbol]; !ok && entry.Symbol != "" {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func readErrorState(dir string) (map[string]errorStateEntry, error) {
+	file, err := os.Open(filepath.Join(dir, errorStateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]errorStateEntry{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := map[string]errorStateEntry{}
+	if err := json.NewDecoder(file).Decode(&entries); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid error state file: %v", err)
+	}
+	return entries, nil
+}
+
+func writeErrorState(dir string, entries map[string]errorStateEntry) error {
+	fullPath := filepath.Join(dir, errorStateFile)
+	if len(entries) == 0 {
+		if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmpPath := fullPath + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(tmpFile)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, fullPath)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStockPriceClassifiesErrors(t *testing.T) {
+	t.Setenv("STOCKS_NOTIFIER_ALLOW_DELAYED", "1")
+
+	unknown := &fakeQuoteProvider{name: "primary", err: classify(errUnknownSymbol, fmt.Errorf("not listed"))}
+	alsoUnknown := &fakeQuoteProvider{name: "secondary", delayed: true, err: classify(errUnknownSymbol, fmt.Errorf("N/D"))}
+	useFakeProviders(t, unknown, alsoUnknown)
+	if _, err := GetStockPrice(context.Background(), "NOPE"); errorClass(err) != errorClassUnknownSymbol {
+		t.Fatalf("expected unknown symbol, got %s: %v", errorClass(err), err)
+	}
+	if breakerFor("primary").failureCount != 0 {
+		t.Fatalf("unknown symbols should not trip the provider breaker")
+	}
+
+	down := &fakeQuoteProvider{name: "primary", err: fmt.Errorf("connection refused")}
+	useFakeProviders(t, down, alsoUnknown)
+	if _, err := GetStockPrice(context.Background(), "AAPL"); errorClass(err) != errorClassProviderDown || !errors.Is(err, errProviderDown) {
+		t.Fatalf("expected provider down, got %s: %v", errorClass(err), err)
+	}
+
+	useFakeProviders(t, &fakeQuoteProvider{name: "primary", delayed: true, err: fmt.Errorf("unused")})
+	t.Setenv("STOCKS_NOTIFIER_ALLOW_DELAYED", "0")
+	if _, err := GetStockPrice(context.Background(), "AAPL"); errorClass(err) != errorClassConfig {
+		t.Fatalf("expected config error when only delayed providers are disabled, got %s: %v", errorClass(err), err)
+	}
+
+	if _, err := parseStooqCSV("XXX", strings.NewReader("XXX.US,N/D,N/D,N/D,N/D,N/D,N/D,N/D\n")); !errors.Is(err, errUnknownSymbol) {
+		t.Fatalf("expected stooq N/D response to be an unknown symbol, got: %v", err)
+	}
+}
+
+func TestErrorTrackerDeduplicatesWithBackoff(t *testing.T) {
+	tracker := newErrorTracker(time.Hour, nil)
+	start := time.Unix(1_700_000_000, 0)
+	outage := classify(errProviderDown, fmt.Errorf("quote lookup failed"))
+
+	first, ok := tracker.report("AAPL", outage, start)
+	if !ok || first.Kind != notificationError || first.ErrorClass != errorClassProviderDown {
+		t.Fatalf("expected first error to be notified, got %+v (%v)", first, ok)
+	}
+	for i := 1; i <= 5; i++ {
+		if _, ok := tracker.report("AAPL", outage, start.Add(time.Duration(i)*10*time.Minute)); ok {
+			t.Fatalf("expected repeat %d within the backoff to be suppressed", i)
+		}
+	}
+	if _, ok := tracker.report("MSFT", outage, start.Add(time.Minute)); !ok {
+		t.Fatalf("expected another symbol to be notified separately")
+	}
+	if _, ok := tracker.report("AAPL", classify(errUnknownSymbol, fmt.Errorf("delisted")), start.Add(time.Minute)); !ok {
+		t.Fatalf("expected a different error class to be notified separately")
+	}
+
+	reminder, ok := tracker.report("AAPL", outage, start.Add(time.Hour))
+	if !ok || !strings.Contains(reminder.Message, "5 repeats not notified") {
+		t.Fatalf("expected reminder after the backoff, got %+v (%v)", reminder, ok)
+	}
+
+	recovered, ok := tracker.resolve("AAPL", start.Add(2*time.Hour))
+	if !ok || recovered.Kind != notificationRecovered || !strings.Contains(recovered.Message, "AAPL is quoting again after 2h0m0s") {
+		t.Fatalf("unexpected recovery notification: %+v (%v)", recovered, ok)
+	}
+	if _, ok := tracker.resolve("AAPL", start.Add(3*time.Hour)); ok {
+		t.Fatalf("expected recovery to be notified once")
+	}
+
+	tracker.prune(map[string][]AlertRule{"AAPL": {{Threshold: 1}}})
+	if _, ok := tracker.entries[errorStateKey("MSFT", errorClassProviderDown)]; ok {
+		t.Fatalf("expected errors for removed symbols to be pruned")
+	}
+}
+
+func TestErrorStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	tracker := newErrorTracker(time.Hour, nil)
+	start := time.Unix(1_700_000_000, 0)
+	tracker.report("", classify(errConfig, fmt.Errorf("invalid JSON")), start)
+
+	if err := writeErrorState(dir, tracker.entries); err != nil {
+		t.Fatalf("writeErrorState failed: %v", err)
+	}
+	entries, err := readErrorState(dir)
+	if err != nil {
+		t.Fatalf("readErrorState failed: %v", err)
+	}
+
+	// A restart inside the backoff must not notify the same error again.
+	restarted := newErrorTracker(time.Hour, entries)
+	if _, ok := restarted.report("", classify(errConfig, fmt.Errorf("invalid JSON")), start.Add(time.Minute)); ok {
+		t.Fatalf("expected persisted error to stay deduplicated after restart")
+	}
+	if n, ok := restarted.resolve("", start.Add(time.Hour)); !ok || !strings.Contains(n.Message, "configuration is readable again") {
+		t.Fatalf("unexpected config recovery: %+v (%v)", n, ok)
+	}
+
+	if err := writeErrorState(dir, restarted.entries); err != nil {
+		t.Fatalf("writeErrorState failed: %v", err)
+	}
+	if entries, err := readErrorState(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected empty error state after recovery, got %v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Embed the timezone database so exchange hours work on systems without
+	// one installed, such as Windows.
+	_ "time/tzdata"
+)
+
+const (
+	exchangeUS  = "us"
+	exchangeNSE = "nse"
+	exchangeLSE = "lse"
+
+	holidayDateLayout = "2006-01-02"
+
+	// Trading sessions reported in Quote.Session. sessionRegular doubles as
+	// the rule scope for regular hours only.
+	sessionPre     = "pre"
+	sessionRegular = "regular"
+	sessionPost    = "post"
+	sessionClosed  = "closed"
+
+	// Rule scopes for AlertRule.Session besides sessionRegular.
+	sessionExtended = "extended"
+	sessionAlways   = "always"
+)
+
+// MarketHoursSettings controls the market calendar. When every watched
+// market is closed the notifier sleeps until the next one opens.
+type MarketHoursSettings struct {
+	// Disabled polls around the clock regardless of market hours.
+	Disabled bool `json:"disabled,omitempty"`
+	// HolidaysFile is a JSON object mapping exchange codes ("us", "nse",
+	// "lse") to lists of YYYY-MM-DD dates the exchange is closed. Relative
+	// paths are resolved against the stocks.json directory.
+	HolidaysFile string `json:"holidaysFile,omitempty"`
+}
+
+// marketExchange describes the trading sessions of an exchange.
+type marketExchange struct {
+	code     string
+	name     string
+	location *time.Location
+	// open and close bound the regular session and extendedOpen and
+	// extendedClose the pre-market and after-hours sessions around it, all
+	// in minutes after local midnight.
+	open          int
+	close         int
+	extendedOpen  int
+	extendedClose int
+}
+
+type marketCalendar struct {
+	exchanges map[string]marketExchange
+	holidays  map[string]map[string]bool
+}
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+var marketExchanges = map[string]marketExchange{
+	exchangeUS:  {code: exchangeUS, name: "NYSE/NASDAQ", location: mustLoadLocation("America/New_York"), open: 9*60 + 30, close: 16 * 60, extendedOpen: 4 * 60, extendedClose: 20 * 60},
+	exchangeNSE: {code: exchangeNSE, name: "NSE", location: mustLoadLocation("Asia/Kolkata"), open: 9*60 + 15, close: 15*60 + 30, extendedOpen: 9 * 60, extendedClose: 16 * 60},
+	// The LSE has no extended trading sessions.
+	exchangeLSE: {code: exchangeLSE, name: "LSE", location: mustLoadLocation("Europe/London"), open: 8 * 60, close: 16*60 + 30, extendedOpen: 8 * 60, extendedClose: 16*60 + 30},
+}
+
+// newMarketCalendar loads the holidays file, if any. It returns nil when
+// market hours are disabled.
+func newMarketCalendar(dir string, settings *MarketHoursSettings) (*marketCalendar, error) {
+	if settings != nil && settings.Disabled {
+		return nil, nil
+	}
+
+	calendar := &marketCalendar{exchanges: marketExchanges, holidays: map[string]map[string]bool{}}
+	if settings == nil || strings.TrimSpace(settings.HolidaysFile) == "" {
+		return calendar, nil
+	}
+
+	path := strings.TrimSpace(settings.HolidaysFile)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read holidays file: %v", err)
+	}
+	var holidays map[string][]string
+	if err := json.Unmarshal(raw, &holidays); err != nil {
+		return nil, fmt.Errorf("invalid holidays file %s: %v", path, err)
+	}
+	for code, dates := range holidays {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if _, ok := marketExchanges[code]; !ok {
+			return nil, fmt.Errorf("holidays file %s: unknown exchange %q", path, code)
+		}
+		if calendar.holidays[code] == nil {
+			calendar.holidays[code] = map[string]bool{}
+		}
+		for _, date := range dates {
+			if _, err := time.Parse(holidayDateLayout, strings.TrimSpace(date)); err != nil {
+				return nil, fmt.Errorf("holidays file %s: invalid %s date %q", path, code, date)
+			}
+			calendar.holidays[code][strings.TrimSpace(date)] = true
+		}
+	}
+	return calendar, nil
+}
+
+// exchangeForSymbol maps a symbol to its exchange using the same suffixes as
+// normalizeStooqSymbol. Unknown suffixes return "".
+func exchangeForSymbol(symbol string) string {
+	normalized := normalizeStooqSymbol(symbol)
+	suffix := normalized[strings.LastIndex(normalized, ".")+1:]
+	switch suffix {
+	case "us":
+		return exchangeUS
+	case "ns":
+		return exchangeNSE
+	case "l", "uk":
+		return exchangeLSE
+	default:
+		return ""
+	}
+}
+
+// ruleInSession reports whether the rule applies to a quote from the given
+// session. Quotes without a known session always apply.
+func ruleInSession(rule AlertRule, session string) bool {
+	if session == "" {
+		return true
+	}
+	switch rule.Session {
+	case sessionRegular:
+		return session == sessionRegular
+	case sessionExtended:
+		return session != sessionClosed
+	default:
+		return true
+	}
+}
+
+func (c *marketCalendar) isTradingDay(exchange marketExchange, day time.Time) bool {
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.holidays[exchange.code][day.Format(holidayDateLayout)]
+}
+
+func atMinute(day time.Time, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, day.Location())
+}
+
+// nextOpen returns now if the symbol's market is open, otherwise when it next
+// opens. extended includes the pre-market and after-hours sessions. Symbols
+// on unknown exchanges are treated as always open.
+func (c *marketCalendar) nextOpen(symbol string, now time.Time, extended bool) time.Time {
+	if c == nil {
+		return now
+	}
+	exchange, ok := c.exchanges[exchangeForSymbol(symbol)]
+	if !ok {
+		return now
+	}
+	openMinute, closeMinute := exchange.open, exchange.close
+	if extended {
+		openMinute, closeMinute = exchange.extendedOpen, exchange.extendedClose
+	}
+
+	local := now.In(exchange.location)
+	// Two weeks comfortably covers weekends plus the longest holiday runs.
+	for offset := 0; offset < 14; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, exchange.location)
+		if !c.isTradingDay(exchange, day) {
+			continue
+		}
+		opensAt := atMinute(day, openMinute)
+		if !now.After(opensAt) {
+			return opensAt
+		}
+		if now.Before(atMinute(day, closeMinute)) {
+			return now
+		}
+	}
+	return now
+}
+
+func (c *marketCalendar) isOpen(symbol string, now time.Time, extended bool) bool {
+	return !c.nextOpen(symbol, now, extended).After(now)
+}
+
+// sessionAt names the trading session of the symbol's market at t, or ""
+// when the exchange is unknown.
+func (c *marketCalendar) sessionAt(symbol string, t time.Time) string {
+	if c == nil || t.IsZero() {
+		return ""
+	}
+	exchange, ok := c.exchanges[exchangeForSymbol(symbol)]
+	if !ok {
+		return ""
+	}
+
+	local := t.In(exchange.location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, exchange.location)
+	switch {
+	case !c.isTradingDay(exchange, day):
+		return sessionClosed
+	case local.Before(atMinute(day, exchange.extendedOpen)):
+		return sessionClosed
+	case local.Before(atMinute(day, exchange.open)):
+		return sessionPre
+	case local.Before(atMinute(day, exchange.close)):
+		return sessionRegular
+	case local.Before(atMinute(day, exchange.extendedClose)):
+		return sessionPost
+	default:
+		return sessionClosed
+	}
+}
+
+// allClosedUntil reports whether the market of every symbol is closed at now
+// and, if so, when the first of them opens. The map value selects extended
+// hours for that symbol.
+func (c *marketCalendar) allClosedUntil(symbols map[string]bool, now time.Time) (time.Time, bool) {
+	if c == nil || len(symbols) == 0 {
+		return time.Time{}, false
+	}
+
+	var earliest time.Time
+	for symbol, extended := range symbols {
+		open := c.nextOpen(symbol, now, extended)
+		if !open.After(now) {
+			return time.Time{}, false
+		}
+		if earliest.IsZero() || open.Before(earliest) {
+			earliest = open
+		}
+	}
+	return earliest, true
+}
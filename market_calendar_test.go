@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchangeForSymbol(t *testing.T) {
+	cases := map[string]string{
+		"AAPL":    exchangeUS,
+		"brk.us":  exchangeUS,
+		"INFY.NS": exchangeNSE,
+		"VOD.L":   exchangeLSE,
+		"VOD.UK":  exchangeLSE,
+		"SAP.DE":  "",
+	}
+	for symbol, want := range cases {
+		if got := exchangeForSymbol(symbol); got != want {
+			t.Fatalf("exchangeForSymbol(%q) = %q, want %q", symbol, got, want)
+		}
+	}
+}
+
+func TestMarketCalendarNextOpen(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "holidays.json"), []byte(`{"us": ["2024-03-29"]}`), 0644); err != nil {
+		t.Fatalf("failed writing holidays: %v", err)
+	}
+	calendar, err := newMarketCalendar(dir, &MarketHoursSettings{HolidaysFile: "holidays.json"})
+	if err != nil {
+		t.Fatalf("newMarketCalendar failed: %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	kolkata, _ := time.LoadLocation("Asia/Kolkata")
+
+	cases := []struct {
+		name   string
+		symbol string
+		now    time.Time
+		want   time.Time
+	}{
+		{"US session open", "AAPL", time.Date(2024, 3, 26, 11, 0, 0, 0, newYork), time.Date(2024, 3, 26, 11, 0, 0, 0, newYork)},
+		{"US before the bell", "AAPL", time.Date(2024, 3, 26, 7, 0, 0, 0, newYork), time.Date(2024, 3, 26, 9, 30, 0, 0, newYork)},
+		{"US after close", "AAPL", time.Date(2024, 3, 26, 16, 0, 0, 0, newYork), time.Date(2024, 3, 27, 9, 30, 0, 0, newYork)},
+		{"US Good Friday holiday and weekend", "AAPL", time.Date(2024, 3, 28, 17, 0, 0, 0, newYork), time.Date(2024, 4, 1, 9, 30, 0, 0, newYork)},
+		{"NSE open on the US holiday", "INFY.NS", time.Date(2024, 3, 29, 10, 0, 0, 0, kolkata), time.Date(2024, 3, 29, 10, 0, 0, 0, kolkata)},
+		{"unknown exchange is always open", "SAP.DE", time.Date(2024, 3, 30, 3, 0, 0, 0, time.UTC), time.Date(2024, 3, 30, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		if got := calendar.nextOpen(tc.symbol, tc.now, false); !got.Equal(tc.want) {
+			t.Fatalf("%s: nextOpen = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPollIntervalSleepsUntilMarketsOpen(t *testing.T) {
+	calendar, err := newMarketCalendar("", nil)
+	if err != nil {
+		t.Fatalf("newMarketCalendar failed: %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	saturday := time.Date(2024, 3, 30, 12, 0, 0, 0, newYork)
+
+	rules := map[string][]AlertRule{
+		"AAPL":  {{Threshold: 180, Direction: directionBelow, Session: sessionRegular}},
+		"VOD.L": {{Threshold: 70, Direction: directionBelow}},
+	}
+	quotes := map[string]Quote{"AAPL": {Price: 179}, "VOD.L": {Price: 71}}
+
+	interval, reason := determineNextPollInterval(quotes, rules, 10*time.Minute, 2*time.Minute, 2, saturday, calendar)
+	// London opens Monday 08:00 BST, which is 03:00 in New York.
+	want := time.Date(2024, 4, 1, 3, 0, 0, 0, newYork).Sub(saturday)
+	if interval != want || !strings.Contains(reason, "all markets closed") {
+		t.Fatalf("expected to sleep until Monday's London open (%v), got %v (%s)", want, interval, reason)
+	}
+
+	// On Monday morning London is open but New York is in pre-market, so the
+	// regular-hours AAPL rule being in alert must not shorten the interval.
+	mondayMorning := time.Date(2024, 4, 1, 5, 0, 0, 0, newYork)
+	interval, reason = determineNextPollInterval(quotes, rules, 10*time.Minute, 2*time.Minute, 2, mondayMorning, calendar)
+	if interval != 2*time.Minute || !strings.Contains(reason, "VOD.L") {
+		t.Fatalf("expected only the open London market to drive polling, got %v (%s)", interval, reason)
+	}
+
+	if disabled, err := newMarketCalendar("", &MarketHoursSettings{Disabled: true}); disabled != nil || err != nil {
+		t.Fatalf("expected disabled calendar to be nil, got %v (%v)", disabled, err)
+	}
+}
+
+func TestMarketCalendarRejectsBadHolidays(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"exchange.json": `{"nyse": ["2024-03-29"]}`,
+		"date.json":     `{"us": ["29/03/2024"]}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed writing %s: %v", name, err)
+		}
+		if _, err := newMarketCalendar(dir, &MarketHoursSettings{HolidaysFile: name}); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestMarketCalendarSessions(t *testing.T) {
+	calendar, err := newMarketCalendar("", nil)
+	if err != nil {
+		t.Fatalf("newMarketCalendar failed: %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	cases := []struct {
+		symbol string
+		at     time.Time
+		want   string
+	}{
+		{"AAPL", time.Date(2024, 3, 26, 3, 0, 0, 0, newYork), sessionClosed},
+		{"AAPL", time.Date(2024, 3, 26, 8, 0, 0, 0, newYork), sessionPre},
+		{"AAPL", time.Date(2024, 3, 26, 9, 30, 0, 0, newYork), sessionRegular},
+		{"AAPL", time.Date(2024, 3, 26, 17, 0, 0, 0, newYork), sessionPost},
+		{"AAPL", time.Date(2024, 3, 30, 12, 0, 0, 0, newYork), sessionClosed},
+		{"SAP.DE", time.Date(2024, 3, 26, 12, 0, 0, 0, newYork), ""},
+	}
+	for _, tc := range cases {
+		if got := calendar.sessionAt(tc.symbol, tc.at); got != tc.want {
+			t.Fatalf("sessionAt(%q, %v) = %q, want %q", tc.symbol, tc.at, got, tc.want)
+		}
+	}
+
+	preMarket := time.Date(2024, 3, 26, 8, 0, 0, 0, newYork)
+	if calendar.isOpen("AAPL", preMarket, false) || !calendar.isOpen("AAPL", preMarket, true) {
+		t.Fatalf("expected pre-market to count as open only for extended hours")
+	}
+}
+
+func TestRuleSessionScopes(t *testing.T) {
+	quote := Quote{Symbol: "AAPL", Price: 170, Source: providerStockpricesDev, Session: sessionPre}
+	cases := []struct {
+		session string
+		want    bool
+	}{
+		{"", true},
+		{sessionAlways, true},
+		{sessionExtended, true},
+		{sessionRegular, false},
+	}
+	for _, tc := range cases {
+		rule := AlertRule{Threshold: 180, Direction: directionBelow, Session: tc.session}
+		if got := shouldSendAlert(quote, rule); got != tc.want {
+			t.Fatalf("session %q: shouldSendAlert in pre-market = %v, want %v", tc.session, got, tc.want)
+		}
+	}
+
+	quote.Session = sessionClosed
+	if shouldSendAlert(quote, AlertRule{Threshold: 180, Direction: directionBelow, Session: sessionExtended}) {
+		t.Fatalf("expected extended rule to be skipped while the market is closed")
+	}
+
+	quote.Session = sessionPost
+	if got := formatQuoteSummary(quote); got != "170.00 [stockprices.dev, after-hours]" {
+		t.Fatalf("expected summary to name the session, got %q", got)
+	}
+
+	rule := AlertRule{Threshold: 180, Session: " Regular "}
+	if err := rule.normalize(); err != nil || rule.Session != sessionRegular {
+		t.Fatalf("expected session to normalize to regular, got %q (%v)", rule.Session, err)
+	}
+	rule = AlertRule{Threshold: 180, Session: "overnight"}
+	if err := rule.normalize(); err == nil {
+		t.Fatalf("expected unknown session to be rejected")
+	}
+}
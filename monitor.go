@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Monitor polls quotes for the rules in a stocks.json directory and sends
+// notifications until its context is cancelled.
+type Monitor struct {
+	dir    string
+	reload chan struct{}
+
+	cfg        monitorConfig
+	alertState map[string]symbolAlertState
+	tracker    *errorTracker
+
+	// rules is the last stocks.json that parsed, nil until one has.
+	rules         map[string][]AlertRule
+	rulesStamp    fileStamp
+	rulesChecked  bool
+	rulesError    string
+	settingsStamp fileStamp
+	settingsError string
+
+	// mu guards status, which the web UI reads while the monitor runs.
+	mu     sync.Mutex
+	status monitorStatus
+}
+
+// monitorStatus is what the monitor saw in its latest poll. It is served to
+// the web UI as live status.
+type monitorStatus struct {
+	Running     bool              `json:"running"`
+	LastPoll    time.Time         `json:"lastPoll"`
+	NextPoll    time.Time         `json:"nextPoll"`
+	SleepReason string            `json:"sleepReason,omitempty"`
+	ConfigError string            `json:"configError,omitempty"`
+	Quotes      map[string]Quote  `json:"quotes,omitempty"`
+	Errors      map[string]string `json:"errors,omitempty"`
+	// AlertsFired counts the alerts sent in the poll: those sent right away
+	// or in a digest, and held alerts released in the quiet hours summary.
+	// Alerts held for quiet hours are counted when they are released.
+	AlertsFired int `json:"alertsFired"`
+
+	// rules and state are copies taken at the end of the poll; settings and
+	// calendar are the ones the poll ran with.
+	rules    map[string][]AlertRule
+	state    map[string]symbolAlertState
+	settings AppSettings
+	calendar *marketCalendar
+}
+
+// monitorConfig is everything the monitor derives from the settings file and
+// environment. It is rebuilt as a whole on reload.
+type monitorConfig struct {
+	settings             AppSettings
+	notifiers            []Notifier
+	quietHours           *quietSchedule
+	digest               *alertDigest
+	calendar             *marketCalendar
+	reminderInterval     time.Duration
+	basePollInterval     time.Duration
+	nearPollInterval     time.Duration
+	nearThresholdPercent float64
+	defaultRearmPercent  float64
+	errorBackoff         time.Duration
+	fetchWorkers         int
+}
+
+// newMonitorConfig validates settings and, when they are valid, makes them
+// the active settings.
+func newMonitorConfig(dir string, settings AppSettings) (monitorConfig, error) {
+	if _, err := resolveProviderChain(settings.Providers); err != nil {
+		return monitorConfig{}, fmt.Errorf("invalid provider chain in settings: %v", err)
+	}
+	if err := validateProviderLimits(settings.ProviderLimits); err != nil {
+		return monitorConfig{}, fmt.Errorf("invalid settings: %v", err)
+	}
+
+	notifiers, err := buildNotifiers(dir, settings.Channels, settings.Templates)
+	if err != nil {
+		return monitorConfig{}, fmt.Errorf("invalid notification channels in settings: %v", err)
+	}
+	quietHours, err := newQuietSchedule(settings.QuietHours)
+	if err != nil {
+		return monitorConfig{}, fmt.Errorf("invalid quiet hours in settings: %v", err)
+	}
+	digest, err := newAlertDigest(settings.Digest)
+	if err != nil {
+		return monitorConfig{}, fmt.Errorf("invalid digest settings: %v", err)
+	}
+	calendar, err := newMarketCalendar(dir, settings.MarketHours)
+	if err != nil {
+		return monitorConfig{}, fmt.Errorf("invalid market hours settings: %v", err)
+	}
+
+	setAppSettings(settings)
+	return monitorConfig{
+		settings:             settings,
+		notifiers:            notifiers,
+		quietHours:           quietHours,
+		digest:               digest,
+		calendar:             calendar,
+		reminderInterval:     getReminderIntervalFromEnv(),
+		basePollInterval:     getDurationWithSetting("STOCKS_NOTIFIER_POLL_INTERVAL", settings.PollInterval, defaultPollInterval),
+		nearPollInterval:     getDurationWithSetting("STOCKS_NOTIFIER_POLL_NEAR_INTERVAL", settings.PollNearInterval, defaultNearInterval),
+		nearThresholdPercent: getNearThresholdPercentFromEnv(),
+		defaultRearmPercent:  getRearmPercentFromEnv(),
+		errorBackoff:         getErrorBackoffFromEnv(),
+		fetchWorkers:         getFetchWorkersFromEnv(),
+	}, nil
+}
+
+// NewMonitor loads the settings and saved state for dir. An unreadable
+// settings file falls back to defaults; invalid settings are an error.
+func NewMonitor(dir string) (*Monitor, error) {
+	// Stamp the settings before reading them so an edit made in between is
+	// picked up by the first check.
+	settingsStamp := statFile(filepath.Join(dir, settingsFile))
+	settings, err := readAppSettings(dir)
+	var settingsError string
+	if err != nil {
+		log.Printf("Failed to read settings file, using defaults/env: %v", err)
+		settingsError = fmt.Sprintf("failed to read settings file, using defaults: %v", err)
+	}
+	cfg, err := newMonitorConfig(dir, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	alertState, err := readAlertState(dir)
+	if err != nil {
+		log.Printf("Failed to read alert state, starting fresh: %v", err)
+		alertState = map[string]symbolAlertState{}
+	}
+	errorEntries, err := readErrorState(dir)
+	if err != nil {
+		log.Printf("Failed to read error state, starting fresh: %v", err)
+	}
+
+	return &Monitor{
+		dir:           dir,
+		reload:        make(chan struct{}, 1),
+		cfg:           cfg,
+		alertState:    alertState,
+		tracker:       newErrorTracker(cfg.errorBackoff, errorEntries),
+		settingsStamp: settingsStamp,
+		settingsError: settingsError,
+	}, nil
+}
+
+// Reload asks the monitor to re-read its settings and poll immediately. It
+// never blocks; requests made while one is pending are merged. A nil monitor
+// ignores the request.
+func (m *Monitor) Reload() {
+	if m == nil {
+		return
+	}
+	select {
+	case m.reload <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls until ctx is cancelled. It polls early when Reload is called or
+// stocks.json or the settings file change. A cancelled cycle finishes the
+// notification in progress, sends any pending digest and saves state before
+// Run returns.
+func (m *Monitor) Run(ctx context.Context) {
+	m.publish(monitorStatus{Running: true})
+	for {
+		sleepFor, reason := m.poll(ctx)
+		if ctx.Err() != nil {
+			m.shutdown()
+			return
+		}
+
+		log.Printf("Sleeping for %s (%s)", sleepFor, reason)
+		if !m.wait(ctx, sleepFor) {
+			m.shutdown()
+			return
+		}
+	}
+}
+
+// RunOnce runs a single poll cycle for cron and CI use and returns its
+// status. Pending digest alerts are sent before it returns since there is no
+// later cycle to send them; alerts held for quiet hours stay in the state file
+// for the first run after the window ends.
+func (m *Monitor) RunOnce(ctx context.Context) monitorStatus {
+	m.poll(ctx)
+	m.shutdown()
+	return m.snapshot()
+}
+
+// wait sleeps until the next poll is due, a reload is requested or a config
+// file changes. It returns false when ctx is cancelled. Queued webhook
+// deliveries that fall due in the meantime are retried without polling, so
+// they are not held back while the markets are closed.
+func (m *Monitor) wait(ctx context.Context, sleepFor time.Duration) bool {
+	timer := time.NewTimer(sleepFor)
+	defer timer.Stop()
+	watch := time.NewTicker(configCheckInterval)
+	defer watch.Stop()
+	flush := m.outboxTimer()
+	defer func() { flush.Stop() }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-m.reload:
+			m.applyReload()
+			return true
+		case <-watch.C:
+			if m.configChanged() {
+				return true
+			}
+		case <-flush.C:
+			flushOutboxes(m.cfg.notifiers)
+			flush = m.outboxTimer()
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// outboxTimer fires when the next queued webhook delivery is due, and never
+// when nothing is queued. It waits at least configCheckInterval so a flush
+// that cannot update the outbox does not spin.
+func (m *Monitor) outboxTimer() *time.Timer {
+	due, ok := nextOutboxAttempt(m.dir, m.cfg.notifiers)
+	if !ok {
+		timer := time.NewTimer(time.Hour)
+		timer.Stop()
+		return timer
+	}
+	return time.NewTimer(max(time.Until(due), configCheckInterval))
+}
+
+// snapshot returns the status published by the latest poll. A nil monitor
+// reports that it is not running.
+func (m *Monitor) snapshot() monitorStatus {
+	if m == nil {
+		return monitorStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *Monitor) publish(status monitorStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = status
+}
+
+func (m *Monitor) shutdown() {
+	log.Printf("Shutting down")
+	m.mu.Lock()
+	m.status.Running = false
+	m.mu.Unlock()
+	if notification, ok := m.cfg.digest.drain(time.Now()); ok {
+		dispatchNotification(m.cfg.notifiers, notification)
+		m.mu.Lock()
+		m.status.AlertsFired += alertCount(notification)
+		m.mu.Unlock()
+	}
+	m.persist()
+	waitQueuedNotifications(m.cfg.notifiers)
+}
+
+func (m *Monitor) persist() {
+	if err := writeAlertState(m.dir, m.alertState); err != nil {
+		log.Printf("Failed to persist alert state: %v", err)
+	}
+	if err := writeErrorState(m.dir, m.tracker.entries); err != nil {
+		log.Printf("Failed to persist error state: %v", err)
+	}
+}
+
+// poll runs one cycle: it fetches every quote, sends the notifications that
+// are due, saves state and returns how long to sleep before the next cycle.
+// Symbols are evaluated in sorted order so notifications go out in the same
+// order every cycle regardless of which fetch finished first.
+func (m *Monitor) poll(ctx context.Context) (time.Duration, string) {
+	cfg := m.cfg
+	flushOutboxes(cfg.notifiers)
+	status := monitorStatus{Running: true, LastPoll: time.Now(), Errors: map[string]string{}}
+
+	m.loadRules(cfg.notifiers)
+	stocks := m.rules
+	var configErrors []string
+	for _, message := range []string{m.rulesError, m.settingsError} {
+		if message != "" {
+			configErrors = append(configErrors, message)
+		}
+	}
+	status.ConfigError = strings.Join(configErrors, "; ")
+
+	quietUntil, inQuietHours := cfg.quietHours.activeUntil(time.Now())
+	if !inQuietHours {
+		if held := releaseHeldAlerts(m.alertState); len(held) > 0 {
+			location := time.Local
+			if cfg.quietHours != nil {
+				location = cfg.quietHours.location
+			}
+			dispatchNotification(cfg.notifiers, newQuietSummaryNotification(held, location, time.Now()))
+			status.AlertsFired += len(held)
+		}
+	}
+
+	symbols := make([]string, 0, len(stocks))
+	for symbol := range stocks {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	results := fetchQuotes(ctx, symbols, cfg.fetchWorkers)
+
+	quotes := make(map[string]Quote, len(stocks))
+	evaluatedRules := make(map[string][]AlertRule, len(stocks))
+	for _, symbol := range symbols {
+		if ctx.Err() != nil {
+			break
+		}
+		symbolRules := stocks[symbol]
+
+		result := results[symbol]
+		if result.err != nil {
+			if notification, ok := m.tracker.report(symbol, result.err, time.Now()); ok {
+				dispatchNotification(cfg.notifiers, notification)
+			}
+			log.Printf("Error: %v", result.err)
+			status.Errors[symbol] = result.err.Error()
+			// Keep the symbol so its market still counts as watched.
+			evaluatedRules[symbol] = symbolRules
+			continue
+		}
+		if notification, ok := m.tracker.resolve(symbol, time.Now()); ok {
+			dispatchNotification(cfg.notifiers, notification)
+		}
+
+		quote := result.quote
+		quote.Session = cfg.calendar.sessionAt(symbol, quote.AsOf)
+		quotes[symbol] = quote
+
+		for _, rule := range symbolRules {
+			if !ruleInSession(rule, quote.Session) {
+				// Leave the state alone so an alert that is still active
+				// stays latched until the rule's session comes round again.
+				evaluatedRules[symbol] = append(evaluatedRules[symbol], rule)
+				log.Printf("Price of stock %q: %s, skipping %s outside its session\n", ruleKey(symbol, rule), formatQuoteSummary(quote), describeRule(rule))
+				continue
+			}
+			if isChangeDirection(rule.Direction) && quote.ChangePercent == nil {
+				// Delayed quotes carry no day change; keep the state as it
+				// was until a quote that has one arrives.
+				evaluatedRules[symbol] = append(evaluatedRules[symbol], rule)
+				log.Printf("Price of stock %q: %s, skipping %s without a day change\n", ruleKey(symbol, rule), formatQuoteSummary(quote), describeRule(rule))
+				continue
+			}
+			if rule.Direction == directionTrailingStop {
+				rule = resolveTrailingStop(rule, updateTrailingPeak(ruleKey(symbol, rule), quote.Price, m.alertState))
+			}
+			evaluatedRules[symbol] = append(evaluatedRules[symbol], rule)
+
+			log.Printf("Price of stock %q: %s, Alert is set for %s\n", ruleKey(symbol, rule), formatQuoteSummary(quote), describeRule(rule))
+
+			inAlert := shouldSendAlert(quote, rule)
+			rearmed := percentDistanceToTrigger(quote, rule) >= effectiveRearmPercent(rule, cfg.defaultRearmPercent)
+			if shouldNotifyAlert(ruleKey(symbol, rule), inAlert, rearmed, cfg.reminderInterval, time.Now(), m.alertState) {
+				notification := newAlertNotification(symbol, rule, quote, time.Now())
+				if inQuietHours && !rule.bypassesQuietHours() {
+					holdAlert(ruleKey(symbol, rule), notification, m.alertState)
+					log.Printf("Quiet hours: holding alert for %q until %s", ruleKey(symbol, rule), quietUntil.Format(time.Kitchen))
+					continue
+				}
+				if cfg.digest != nil {
+					cfg.digest.add(notification)
+					continue
+				}
+				dispatchNotification(cfg.notifiers, notification)
+				status.AlertsFired++
+			}
+		}
+	}
+
+	if notification, ok := cfg.digest.flush(time.Now()); ok {
+		dispatchNotification(cfg.notifiers, notification)
+		status.AlertsFired += alertCount(notification)
+	}
+
+	if ctx.Err() == nil && stocks != nil {
+		// A cancelled cycle leaves rules unevaluated, and until stocks.json
+		// has parsed there are no rules to compare with; keep the state.
+		pruneAlertState(m.alertState, stocks)
+		m.tracker.prune(stocks)
+	}
+	m.persist()
+
+	sleepFor, reason := determineNextPollInterval(quotes, evaluatedRules, cfg.basePollInterval, cfg.nearPollInterval, cfg.nearThresholdPercent, time.Now(), cfg.calendar)
+	if due, ok := cfg.digest.dueAt(); ok && time.Until(due) < sleepFor {
+		sleepFor, reason = time.Until(due), "digest window ends"
+	}
+	if inQuietHours && hasHeldAlerts(m.alertState) {
+		if untilEnd := time.Until(quietUntil); untilEnd < sleepFor {
+			sleepFor, reason = untilEnd, "quiet hours end"
+		}
+	}
+
+	status.NextPoll = time.Now().Add(sleepFor)
+	status.SleepReason = reason
+	status.Quotes = quotes
+	status.rules = stocks
+	status.settings = cfg.settings
+	status.calendar = cfg.calendar
+	status.state = make(map[string]symbolAlertState, len(m.alertState))
+	for key, current := range m.alertState {
+		status.state[key] = current
+	}
+	m.publish(status)
+	return sleepFor, reason
+}
+
+type quoteResult struct {
+	quote Quote
+	err   error
+}
+
+// fetchQuotes looks up symbols with at most workers lookups in flight. The
+// per-provider limits still apply within each lookup. Symbols not reached
+// before ctx is cancelled are missing from the result.
+func fetchQuotes(ctx context.Context, symbols []string, workers int) map[string]quoteResult {
+	if workers <= 0 {
+		workers = defaultFetchWorkers
+	}
+	if workers > len(symbols) {
+		workers = len(symbols)
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]quoteResult, len(symbols))
+		jobs    = make(chan string)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for symbol := range jobs {
+				quote, err := GetStockPrice(ctx, symbol)
+				mu.Lock()
+				results[symbol] = quoteResult{quote: quote, err: err}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, symbol := range symbols {
+		select {
+		case jobs <- symbol:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestMonitor writes stocks.json and a settings file that quotes from the
+// fake provider and sends to a recording channel.
+func newTestMonitor(t *testing.T, stocks string, settings AppSettings) (*Monitor, *recordingNotifier) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stocks.json"), []byte(stocks), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+
+	recorder := &recordingNotifier{name: "recorder"}
+	registerNotifier("recording", func(_, _ string, _ ChannelSettings) (Notifier, error) {
+		return recorder, nil
+	})
+	t.Cleanup(func() { delete(notifierFactories, "recording") })
+
+	settings.Providers = []string{"fake"}
+	settings.Channels = []ChannelSettings{{Type: "recording"}}
+	settings.MarketHours = &MarketHoursSettings{Disabled: true}
+	if err := writeAppSettings(dir, settings); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+
+	monitor, err := NewMonitor(dir)
+	if err != nil {
+		t.Fatalf("NewMonitor failed: %v", err)
+	}
+	return monitor, recorder
+}
+
+func TestFetchQuotesRespectsWorkerAndProviderLimits(t *testing.T) {
+	fake := &fakeQuoteProvider{name: "fake", price: 100, delay: 20 * time.Millisecond}
+	useFakeProviders(t, fake)
+
+	symbols := []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+	results := fetchQuotes(context.Background(), symbols, 3)
+	if len(results) != len(symbols) || results["H"].err != nil || results["H"].quote.Price != 100 {
+		t.Fatalf("expected a quote for every symbol, got: %+v", results)
+	}
+	if fake.maxInFlight < 2 || fake.maxInFlight > 3 {
+		t.Fatalf("expected up to 3 concurrent fetches, got %d", fake.maxInFlight)
+	}
+
+	fake.maxInFlight = 0
+	appSettings.ProviderLimits = map[string]ProviderLimit{"fake": {MaxConcurrent: 1}}
+	fetchQuotes(context.Background(), symbols, 8)
+	if fake.maxInFlight != 1 {
+		t.Fatalf("expected the provider limit to serialize fetches, got %d in flight", fake.maxInFlight)
+	}
+}
+
+func TestMonitorPollSendsAlertsInSymbolOrder(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100, delay: 5 * time.Millisecond})
+	monitor, recorder := newTestMonitor(t, `{"ZZZ": 150, "AAA": 150, "MMM": 150, "QQQ": 50}`, AppSettings{})
+
+	monitor.poll(context.Background())
+
+	var order []string
+	for _, n := range recorder.sent {
+		order = append(order, n.Symbol)
+	}
+	if fmt.Sprint(order) != "[AAA MMM ZZZ]" {
+		t.Fatalf("expected alerts in symbol order, got %v", order)
+	}
+	if !monitor.alertState["AAA"].InAlert {
+		t.Fatalf("expected alert state to be recorded: %+v", monitor.alertState)
+	}
+}
+
+func TestMonitorKeepsAlertLatchedOutsideRuleSession(t *testing.T) {
+	fake := &fakeQuoteProvider{name: "fake", price: 100}
+	useFakeProviders(t, fake)
+	monitor, recorder := newTestMonitor(t, `{"AAPL": {"threshold": 150, "session": "regular"}}`, AppSettings{})
+	monitor.cfg.calendar = &marketCalendar{exchanges: marketExchanges, holidays: map[string]map[string]bool{}}
+
+	newYork := marketExchanges[exchangeUS].location
+	for _, asOf := range []time.Time{
+		time.Date(2024, 1, 8, 11, 0, 0, 0, newYork),
+		time.Date(2024, 1, 8, 18, 0, 0, 0, newYork),
+		time.Date(2024, 1, 9, 11, 0, 0, 0, newYork),
+	} {
+		fake.asOf = asOf
+		monitor.poll(context.Background())
+	}
+
+	if len(recorder.sent) != 1 {
+		t.Fatalf("expected a single alert while the condition held, got: %+v", recorder.sent)
+	}
+	if !monitor.alertState["AAPL"].InAlert {
+		t.Fatalf("expected the alert to stay latched: %+v", monitor.alertState)
+	}
+}
+
+func TestMonitorKeepsChangeRuleStateWithoutDayChange(t *testing.T) {
+	down := -6.0
+	fake := &fakeQuoteProvider{name: "fake", price: 100, changePercent: &down}
+	useFakeProviders(t, fake)
+	monitor, recorder := newTestMonitor(t, `{"NVDA": {"threshold": -5, "direction": "change_pct_below"}}`, AppSettings{})
+
+	monitor.poll(context.Background())
+	fake.changePercent = nil
+	monitor.poll(context.Background())
+	if !monitor.alertState["NVDA"].InAlert {
+		t.Fatalf("expected a quote without a day change to keep the alert latched: %+v", monitor.alertState)
+	}
+	fake.changePercent = &down
+	monitor.poll(context.Background())
+
+	if len(recorder.sent) != 1 {
+		t.Fatalf("expected a single alert while the condition held, got: %+v", recorder.sent)
+	}
+}
+
+func TestMonitorQuietHoursBypassForUrgentAndOptedInRules(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	always := &QuietHoursSettings{Timezone: "UTC", Windows: []QuietWindow{{Start: "00:00", End: "23:59"}, {Start: "23:59", End: "00:00"}}}
+	monitor, recorder := newTestMonitor(t, `{"AAA": {"threshold": 150, "severity": "urgent"}, "BBB": {"threshold": 150, "bypassQuietHours": true}, "CCC": {"threshold": 150, "severity": "high"}}`, AppSettings{QuietHours: always})
+
+	monitor.poll(context.Background())
+
+	if len(recorder.sent) != 2 || recorder.sent[0].Symbol != "AAA" || recorder.sent[1].Symbol != "BBB" {
+		t.Fatalf("expected the urgent and bypassing rules to be sent, got: %+v", recorder.sent)
+	}
+	if monitor.alertState["CCC"].Suppressed == nil {
+		t.Fatalf("expected the high severity rule to be held: %+v", monitor.alertState)
+	}
+}
+
+func TestMonitorRunOnceCountsOnlySentAlerts(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	always := &QuietHoursSettings{Timezone: "UTC", Windows: []QuietWindow{{Start: "00:00", End: "23:59"}, {Start: "23:59", End: "00:00"}}}
+	monitor, recorder := newTestMonitor(t, `{"AAA": 150, "BBB": {"threshold": 150, "bypassQuietHours": true}}`, AppSettings{QuietHours: always})
+
+	status := monitor.RunOnce(context.Background())
+	if len(recorder.sent) != 1 || status.AlertsFired != 1 {
+		t.Fatalf("expected only the sent alert to count, got %d fired: %+v", status.AlertsFired, recorder.sent)
+	}
+	if monitor.alertState["AAA"].Suppressed == nil {
+		t.Fatalf("expected AAA to be held: %+v", monitor.alertState)
+	}
+}
+
+func TestMonitorShutdownSendsPendingDigestAndSavesState(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, recorder := newTestMonitor(t, `{"AAA": 150, "BBB": 150}`, AppSettings{Digest: &DigestSettings{Enabled: true, Window: "1h"}})
+
+	monitor.poll(context.Background())
+	if len(recorder.sent) != 0 {
+		t.Fatalf("expected alerts to wait for the digest window, got: %+v", recorder.sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	monitor.Run(ctx)
+
+	if len(recorder.sent) != 1 || recorder.sent[0].Kind != notificationDigest || len(recorder.sent[0].Alerts) != 2 {
+		t.Fatalf("expected the pending digest on shutdown, got: %+v", recorder.sent)
+	}
+	state, err := readAlertState(monitor.dir)
+	if err != nil || !state["AAA"].InAlert || !state["BBB"].InAlert {
+		t.Fatalf("expected alert state saved on shutdown, got %+v (%v)", state, err)
+	}
+	if _, err := os.Stat(filepath.Join(monitor.dir, alertStateFile+".tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected no temporary state file left behind: %v", err)
+	}
+}
+
+func TestMonitorWaitRetriesQueuedWebhooks(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, _ := newTestMonitor(t, `{"AAA": 50}`, AppSettings{})
+	monitor.poll(context.Background())
+
+	var healthy atomic.Bool
+	delivered := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+	webhook := newTestWebhookNotifier(t, monitor.dir, WebhookConfig{URL: server.URL})
+	monitor.cfg.notifiers = []Notifier{webhook}
+	_ = webhook.Notify(testAlertNotification())
+	if _, ok := nextOutboxAttempt(monitor.dir, monitor.cfg.notifiers); !ok {
+		t.Fatalf("expected a queued delivery")
+	}
+
+	healthy.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan bool)
+	go func() { done <- monitor.wait(ctx, time.Hour) }()
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the queued delivery to be retried while waiting")
+	}
+	cancel()
+	if <-done {
+		t.Fatalf("expected wait to keep sleeping until cancelled")
+	}
+	if _, ok := nextOutboxAttempt(monitor.dir, monitor.cfg.notifiers); ok {
+		t.Fatalf("expected the outbox to be empty after the retry")
+	}
+}
+
+func TestMonitorReloadKeepsPreviousSettingsOnError(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, _ := newTestMonitor(t, `{"AAA": 50}`, AppSettings{PollInterval: "7m"})
+	if monitor.cfg.basePollInterval != 7*time.Minute {
+		t.Fatalf("expected poll interval from settings, got %s", monitor.cfg.basePollInterval)
+	}
+
+	bad := currentSettings()
+	bad.PollInterval = "3m"
+	bad.Providers = []string{"missing"}
+	if err := writeAppSettings(monitor.dir, bad); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	monitor.applyReload()
+	if monitor.cfg.basePollInterval != 7*time.Minute || currentSettings().Providers[0] != "fake" {
+		t.Fatalf("expected invalid settings to be ignored, got %s", monitor.cfg.basePollInterval)
+	}
+
+	good := bad
+	good.Providers = []string{"fake"}
+	if err := writeAppSettings(monitor.dir, good); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	monitor.Reload()
+	monitor.Reload()
+	select {
+	case <-monitor.reload:
+		monitor.applyReload()
+	default:
+		t.Fatalf("expected a pending reload request")
+	}
+	if monitor.cfg.basePollInterval != 3*time.Minute {
+		t.Fatalf("expected reloaded poll interval, got %s", monitor.cfg.basePollInterval)
+	}
+}
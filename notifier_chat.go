@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	channelSlack    = "slack"
+	channelDiscord  = "discord"
+	channelTelegram = "telegram"
+
+	defaultSlackBaseURL    = "https://slack.com/api"
+	defaultDiscordBaseURL  = "https://discord.com/api/webhooks"
+	defaultTelegramBaseURL = "https://api.telegram.org"
+
+	defaultSlackTokenEnv      = "STOCKS_NOTIFIER_SLACK_TOKEN"
+	defaultDiscordWebhookEnv  = "STOCKS_NOTIFIER_DISCORD_WEBHOOK"
+	defaultTelegramTokenEnv   = "STOCKS_NOTIFIER_TELEGRAM_TOKEN"
+	defaultChatChannelTimeout = 10 * time.Second
+)
+
+// SlackConfig posts with chat.postMessage using a bot token.
+type SlackConfig struct {
+	Channel  string `json:"channel"`
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	BaseURL  string `json:"baseURL,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
+// DiscordConfig posts to a channel webhook. The environment variable holds
+// the "<id>/<token>" part of the webhook URL.
+type DiscordConfig struct {
+	WebhookEnv string `json:"webhookEnv,omitempty"`
+	Username   string `json:"username,omitempty"`
+	BaseURL    string `json:"baseURL,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+}
+
+// TelegramConfig sends through the Bot API sendMessage method.
+type TelegramConfig struct {
+	ChatID   string `json:"chatId"`
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	BaseURL  string `json:"baseURL,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
+func init() {
+	registerNotifier(channelSlack, newSlackNotifier)
+	registerNotifier(channelDiscord, newDiscordNotifier)
+	registerNotifier(channelTelegram, newTelegramNotifier)
+}
+
+// quoteLink points chat messages at a public quote page for the symbol.
+func quoteLink(symbol string) string {
+	stooqSymbol := normalizeStooqSymbol(symbol)
+	if stooqSymbol == "" {
+		return ""
+	}
+	return "https://stooq.com/q/?s=" + stooqSymbol
+}
+
+func chatBaseURL(configured, defaultValue string) string {
+	configured = strings.TrimRight(strings.TrimSpace(configured), "/")
+	if configured == "" {
+		return defaultValue
+	}
+	return configured
+}
+
+type slackNotifier struct {
+	name    string
+	channel string
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+func newSlackNotifier(_, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Slack
+	if cfg == nil || strings.TrimSpace(cfg.Channel) == "" {
+		return nil, fmt.Errorf("slack channel needs slack.channel")
+	}
+	token, err := secretFromEnv(cfg.TokenEnv, defaultSlackTokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultChatChannelTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &slackNotifier{
+		name:    name,
+		channel: strings.TrimSpace(cfg.Channel),
+		token:   token,
+		baseURL: chatBaseURL(cfg.BaseURL, defaultSlackBaseURL),
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *slackNotifier) Name() string { return s.name }
+
+func (s *slackNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(buildSlackMessage(s.channel, n))
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	headers := map[string]string{"Authorization": "Bearer " + s.token}
+	if err := postJSONDecode(s.client, s.baseURL+"/chat.postMessage", headers, body, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("slack API error: %s", resp.Error)
+	}
+	return nil
+}
+
+func buildSlackMessage(channel string, n Notification) map[string]any {
+	blocks := []map[string]any{
+		{
+			"type": "header",
+			"text": map[string]any{"type": "plain_text", "text": chatHeadline(n)},
+		},
+	}
+
+	section := map[string]any{
+		"type": "section",
+		"text": map[string]any{"type": "mrkdwn", "text": escapeSlackText(n.Message)},
+	}
+	var fields []map[string]any
+	for _, detail := range notificationDetails(n) {
+		// Slack allows at most ten fields per section.
+		if len(fields) == 10 {
+			break
+		}
+		fields = append(fields, map[string]any{"type": "mrkdwn", "text": "*" + escapeSlackText(detail[0]) + "*\n" + escapeSlackText(detail[1])})
+	}
+	if len(fields) > 0 {
+		section["fields"] = fields
+	}
+	blocks = append(blocks, section)
+
+	if link := quoteLink(n.Symbol); link != "" {
+		blocks = append(blocks, map[string]any{
+			"type": "context",
+			"elements": []map[string]any{
+				{"type": "mrkdwn", "text": fmt.Sprintf("<%s|View %s quote>", link, escapeSlackText(n.Symbol))},
+			},
+		})
+	}
+
+	return map[string]any{
+		"channel": channel,
+		"text":    escapeSlackText(n.Message),
+		"blocks":  blocks,
+	}
+}
+
+var slackTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeSlackText escapes the characters Slack reserves for links and
+// mentions in mrkdwn text.
+func escapeSlackText(text string) string {
+	return slackTextEscaper.Replace(text)
+}
+
+// chatHeadline is the short title used by chat channels. A title rendered
+// from a template is used as is.
+func chatHeadline(n Notification) string {
+	switch {
+	case hasCustomTitle(n):
+		return n.Title
+	case n.Kind == notificationDigest:
+		return fmt.Sprintf("%d stock alerts", len(n.Alerts))
+	case (n.Kind == notificationAlert || n.Kind == notificationTest) && n.Symbol != "":
+		return n.Symbol + " alert"
+	case n.Symbol != "":
+		return n.Symbol + " " + n.Kind
+	default:
+		return n.Title
+	}
+}
+
+type discordNotifier struct {
+	name     string
+	url      string
+	username string
+	client   *http.Client
+}
+
+func newDiscordNotifier(_, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Discord
+	if cfg == nil {
+		cfg = &DiscordConfig{}
+	}
+	webhook, err := secretFromEnv(cfg.WebhookEnv, defaultDiscordWebhookEnv)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultChatChannelTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &discordNotifier{
+		name:     name,
+		url:      chatBaseURL(cfg.BaseURL, defaultDiscordBaseURL) + "/" + strings.Trim(webhook, "/"),
+		username: strings.TrimSpace(cfg.Username),
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (d *discordNotifier) Name() string { return d.name }
+
+func (d *discordNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(buildDiscordMessage(d.username, n))
+	if err != nil {
+		return err
+	}
+	return postJSON(d.client, d.url, nil, body)
+}
+
+const (
+	discordColorUp    = 0x177c3f
+	discordColorDown  = 0xc52828
+	discordColorError = 0xe0a800
+)
+
+func buildDiscordMessage(username string, n Notification) map[string]any {
+	color := discordColorDown
+	switch {
+	case n.Kind == notificationError:
+		color = discordColorError
+	case n.Kind == notificationRecovered:
+		color = discordColorUp
+	case n.Rule != nil && (n.Rule.Direction == directionAbove || n.Rule.Direction == directionChangePctAbove):
+		color = discordColorUp
+	}
+
+	embed := map[string]any{
+		"title":       chatHeadline(n),
+		"description": n.Message,
+		"color":       color,
+	}
+	if link := quoteLink(n.Symbol); link != "" {
+		embed["url"] = link
+	}
+	if !n.Time.IsZero() {
+		embed["timestamp"] = n.Time.UTC().Format(time.RFC3339)
+	}
+	var fields []map[string]any
+	for _, detail := range notificationDetails(n) {
+		fields = append(fields, map[string]any{"name": detail[0], "value": detail[1], "inline": true})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
+
+	message := map[string]any{"embeds": []map[string]any{embed}}
+	if username != "" {
+		message["username"] = username
+	}
+	return message
+}
+
+type telegramNotifier struct {
+	name   string
+	chatID string
+	url    string
+	client *http.Client
+}
+
+func newTelegramNotifier(_, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Telegram
+	if cfg == nil || strings.TrimSpace(cfg.ChatID) == "" {
+		return nil, fmt.Errorf("telegram channel needs telegram.chatId")
+	}
+	token, err := secretFromEnv(cfg.TokenEnv, defaultTelegramTokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultChatChannelTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &telegramNotifier{
+		name:   name,
+		chatID: strings.TrimSpace(cfg.ChatID),
+		url:    chatBaseURL(cfg.BaseURL, defaultTelegramBaseURL) + "/bot" + token + "/sendMessage",
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (t *telegramNotifier) Name() string { return t.name }
+
+func (t *telegramNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  t.chatID,
+		"text":                     buildTelegramMessage(n),
+		"parse_mode":               "MarkdownV2",
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := postJSONDecode(t.client, t.url, nil, body, &resp); err != nil {
+		// Never log the bot token embedded in the URL.
+		return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), t.url, "telegram sendMessage"))
+	}
+	if !resp.OK {
+		return fmt.Errorf("telegram API error: %s", resp.Description)
+	}
+	return nil
+}
+
+func buildTelegramMessage(n Notification) string {
+	var text strings.Builder
+	text.WriteString("*" + escapeTelegramMarkdown(chatHeadline(n)) + "*\n")
+	text.WriteString(escapeTelegramMarkdown(n.Message) + "\n")
+	for _, detail := range notificationDetails(n) {
+		text.WriteString("\n*" + escapeTelegramMarkdown(detail[0]) + ":* " + escapeTelegramMarkdown(detail[1]))
+	}
+	if link := quoteLink(n.Symbol); link != "" {
+		linkEscaper := strings.NewReplacer(`\`, `\\`, `)`, `\)`)
+		text.WriteString("\n\n[" + escapeTelegramMarkdown("View "+n.Symbol+" quote") + "](" + linkEscaper.Replace(link) + ")")
+	}
+	return text.String()
+}
+
+var telegramMarkdownEscaper = func() *strings.Replacer {
+	var pairs []string
+	for _, c := range `\_*[]()~` + "`" + `>#+-=|{}.!` {
+		pairs = append(pairs, string(c), `\`+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// escapeTelegramMarkdown escapes every character reserved by MarkdownV2.
+func escapeTelegramMarkdown(text string) string {
+	return telegramMarkdownEscaper.Replace(text)
+}
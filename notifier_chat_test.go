@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type capturedRequest struct {
+	path    string
+	headers http.Header
+	body    map[string]any
+}
+
+func startChatServer(t *testing.T, response string) (*httptest.Server, *capturedRequest) {
+	t.Helper()
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		captured.path = r.URL.Path
+		captured.headers = r.Header.Clone()
+		_ = json.Unmarshal(raw, &captured.body)
+		if response == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, captured
+}
+
+func TestSlackNotifierPostsBlocks(t *testing.T) {
+	t.Setenv(defaultSlackTokenEnv, "xoxb-test")
+	server, captured := startChatServer(t, `{"ok": true}`)
+
+	notifier, err := newSlackNotifier("", channelSlack, ChannelSettings{Slack: &SlackConfig{Channel: "#alerts", BaseURL: server.URL}})
+	if err != nil {
+		t.Fatalf("newSlackNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if captured.path != "/chat.postMessage" || captured.headers.Get("Authorization") != "Bearer xoxb-test" {
+		t.Fatalf("unexpected request: path=%q auth=%q", captured.path, captured.headers.Get("Authorization"))
+	}
+	blocks, _ := captured.body["blocks"].([]any)
+	if captured.body["channel"] != "#alerts" || len(blocks) != 3 {
+		t.Fatalf("unexpected slack payload: %#v", captured.body)
+	}
+	context, _ := json.Marshal(blocks[2])
+	if !strings.Contains(string(context), "https://stooq.com/q/?s=aapl.us") {
+		t.Fatalf("quote link missing from slack payload: %s", context)
+	}
+}
+
+func TestSlackNotifierReportsAPIErrors(t *testing.T) {
+	t.Setenv(defaultSlackTokenEnv, "xoxb-test")
+	server, _ := startChatServer(t, `{"ok": false, "error": "channel_not_found"}`)
+
+	notifier, err := newSlackNotifier("", channelSlack, ChannelSettings{Slack: &SlackConfig{Channel: "#missing", BaseURL: server.URL}})
+	if err != nil {
+		t.Fatalf("newSlackNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Fatalf("expected slack API error, got: %v", err)
+	}
+}
+
+func TestDiscordNotifierPostsEmbed(t *testing.T) {
+	t.Setenv(defaultDiscordWebhookEnv, "123/abc")
+	server, captured := startChatServer(t, "")
+
+	notifier, err := newDiscordNotifier("", channelDiscord, ChannelSettings{Discord: &DiscordConfig{BaseURL: server.URL}})
+	if err != nil {
+		t.Fatalf("newDiscordNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if captured.path != "/123/abc" {
+		t.Fatalf("unexpected webhook path: %q", captured.path)
+	}
+	embeds, _ := captured.body["embeds"].([]any)
+	if len(embeds) != 1 {
+		t.Fatalf("expected one embed: %#v", captured.body)
+	}
+	embed := embeds[0].(map[string]any)
+	if embed["title"] != "AAPL alert" || embed["url"] != "https://stooq.com/q/?s=aapl.us" || embed["color"] != float64(discordColorDown) {
+		t.Fatalf("unexpected embed: %#v", embed)
+	}
+}
+
+func TestTelegramNotifierSendsMarkdownV2(t *testing.T) {
+	t.Setenv(defaultTelegramTokenEnv, "42:secret")
+	server, captured := startChatServer(t, `{"ok": true}`)
+
+	notifier, err := newTelegramNotifier("", channelTelegram, ChannelSettings{Telegram: &TelegramConfig{ChatID: "-100", BaseURL: server.URL}})
+	if err != nil {
+		t.Fatalf("newTelegramNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if captured.path != "/bot42:secret/sendMessage" || captured.body["parse_mode"] != "MarkdownV2" || captured.body["chat_id"] != "-100" {
+		t.Fatalf("unexpected telegram request: path=%q body=%#v", captured.path, captured.body)
+	}
+	text, _ := captured.body["text"].(string)
+	if !strings.Contains(text, `179\.50`) || !strings.Contains(text, "(https://stooq.com/q/?s=aapl.us)") {
+		t.Fatalf("unexpected telegram text: %q", text)
+	}
+}
+
+func TestEscapeTelegramMarkdown(t *testing.T) {
+	if got := escapeTelegramMarkdown("BRK.B -1.5% (day) [x]_*!"); got != `BRK\.B \-1\.5% \(day\) \[x\]\_\*\!` {
+		t.Fatalf("unexpected escape result: %q", got)
+	}
+}
+
+func TestEscapeSlackText(t *testing.T) {
+	if got := escapeSlackText("M&M <!channel> > 5%"); got != "M&amp;M &lt;!channel&gt; &gt; 5%" {
+		t.Fatalf("unexpected escape result: %q", got)
+	}
+	message := buildSlackMessage("#alerts", Notification{Kind: notificationAlert, Symbol: "AT&T", Message: "AT&T <@here> below 20"})
+	if text := message["text"].(string); text != "AT&amp;T &lt;@here&gt; below 20" {
+		t.Fatalf("expected the fallback text to be escaped, got %q", text)
+	}
+}
+
+func TestChatNotifiersRequireTokensFromEnv(t *testing.T) {
+	t.Setenv(defaultSlackTokenEnv, "")
+	t.Setenv(defaultTelegramTokenEnv, "")
+	if _, err := newSlackNotifier("", channelSlack, ChannelSettings{Slack: &SlackConfig{Channel: "#alerts"}}); err == nil {
+		t.Fatalf("expected error without slack token")
+	}
+	if _, err := newTelegramNotifier("", channelTelegram, ChannelSettings{Telegram: &TelegramConfig{ChatID: "1"}}); err == nil {
+		t.Fatalf("expected error without telegram token")
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	channelEmail           = "email"
+	emailSecurityStartTLS  = "starttls"
+	emailSecurityTLS       = "tls"
+	emailSecurityNone      = "none"
+	defaultEmailTimeout    = 30 * time.Second
+	defaultSMTPPasswordEnv = "STOCKS_NOTIFIER_SMTP_PASSWORD"
+)
+
+type EmailConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	// Security is "starttls" (default), "tls" for implicit TLS, or "none".
+	Security string `json:"security,omitempty"`
+	Username string `json:"username,omitempty"`
+	// PasswordEnv names the environment variable holding the SMTP password
+	// (default STOCKS_NOTIFIER_SMTP_PASSWORD).
+	PasswordEnv string   `json:"passwordEnv,omitempty"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	Timeout     string   `json:"timeout,omitempty"`
+}
+
+type emailNotifier struct {
+	name     string
+	host     string
+	port     int
+	security string
+	username string
+	password string
+	from     string
+	to       []string
+	timeout  time.Duration
+}
+
+func init() {
+	registerNotifier(channelEmail, newEmailNotifier)
+}
+
+func newEmailNotifier(_, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Email
+	if cfg == nil || strings.TrimSpace(cfg.Host) == "" {
+		return nil, fmt.Errorf("email channel needs email.host")
+	}
+	if strings.TrimSpace(cfg.From) == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("email channel needs email.from and email.to")
+	}
+
+	security := strings.ToLower(strings.TrimSpace(cfg.Security))
+	port := cfg.Port
+	switch security {
+	case "", emailSecurityStartTLS:
+		security = emailSecurityStartTLS
+		if port == 0 {
+			port = 587
+		}
+	case emailSecurityTLS:
+		if port == 0 {
+			port = 465
+		}
+	case emailSecurityNone:
+		if port == 0 {
+			port = 25
+		}
+	default:
+		return nil, fmt.Errorf("unsupported email security %q (supported: %q, %q, %q)", cfg.Security, emailSecurityStartTLS, emailSecurityTLS, emailSecurityNone)
+	}
+
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultEmailTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &emailNotifier{
+		name:     name,
+		host:     strings.TrimSpace(cfg.Host),
+		port:     port,
+		security: security,
+		username: strings.TrimSpace(cfg.Username),
+		from:     strings.TrimSpace(cfg.From),
+		to:       cfg.To,
+		timeout:  timeout,
+	}
+	if notifier.username != "" {
+		password, err := secretFromEnv(cfg.PasswordEnv, defaultSMTPPasswordEnv)
+		if err != nil {
+			return nil, err
+		}
+		notifier.password = password
+	}
+
+	return notifier, nil
+}
+
+func (e *emailNotifier) Name() string { return e.name }
+
+func (e *emailNotifier) Notify(n Notification) error {
+	message, err := buildEmailMessage(e.from, e.to, n)
+	if err != nil {
+		return err
+	}
+
+	client, err := e.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if e.security == emailSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server %s does not support STARTTLS", e.host)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+
+	if e.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(e.from); err != nil {
+		return fmt.Errorf("MAIL FROM rejected: %v", err)
+	}
+	for _, recipient := range e.to {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", recipient, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA rejected: %v", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("message rejected: %v", err)
+	}
+
+	return client.Quit()
+}
+
+func (e *emailNotifier) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	dialer := &net.Dialer{Timeout: e.timeout}
+
+	var conn net.Conn
+	var err error
+	if e.security == emailSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: e.host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(e.timeout))
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("SMTP handshake failed: %v", err)
+	}
+	return client, nil
+}
+
+// notificationDetails lists the quote and rule fields shown in rich channels.
+func notificationDetails(n Notification) [][2]string {
+	var details [][2]string
+	add := func(label, value string) {
+		if value != "" {
+			details = append(details, [2]string{label, value})
+		}
+	}
+	addFloat := func(label string, value *float64, format string) {
+		if value != nil {
+			add(label, fmt.Sprintf(format, *value))
+		}
+	}
+
+	add("Symbol", n.Symbol)
+	if q := n.Quote; q != nil {
+		add("Name", q.Name)
+		add("Price", fmt.Sprintf("%.2f", q.Price))
+		addFloat("Change", q.Change, "%+.2f")
+		addFloat("Change %", q.ChangePercent, "%+.2f%%")
+		addFloat("Open", q.Open, "%.2f")
+		addFloat("High", q.High, "%.2f")
+		addFloat("Low", q.Low, "%.2f")
+		if q.Volume != nil {
+			add("Volume", strconv.FormatInt(*q.Volume, 10))
+		}
+		source := q.Source
+		if q.Delayed {
+			source += " (delayed)"
+		}
+		add("Source", source)
+		if !q.AsOf.IsZero() {
+			add("As of", q.AsOf.Format(time.RFC1123))
+		}
+	}
+	if n.Rule != nil {
+		add("Rule", describeRule(*n.Rule))
+	}
+	return details
+}
+
+var emailHTMLTemplate = template.Must(template.New("email").Parse(`<!doctype html>
+<html><body style="font-family: sans-serif; color: #10243b;">
+<p style="font-size: 16px;">{{.Message}}</p>
+{{if .Details}}<table style="border-collapse: collapse;">
+{{range .Details}}<tr><th style="text-align: left; padding: 4px 12px 4px 0; color: #5f738a;">{{index . 0}}</th><td style="padding: 4px 0;">{{index . 1}}</td></tr>
+{{end}}</table>{{end}}
+<p style="font-size: 12px; color: #5f738a;">Sent by Stocks Notifier at {{.Time}}</p>
+</body></html>
+`))
+
+func buildEmailMessage(from string, to []string, n Notification) ([]byte, error) {
+	details := notificationDetails(n)
+	sentAt := n.Time
+	if sentAt.IsZero() {
+		sentAt = time.Now()
+	}
+
+	var text strings.Builder
+	text.WriteString(n.Message + "\r\n\r\n")
+	for _, detail := range details {
+		text.WriteString(detail[0] + ": " + detail[1] + "\r\n")
+	}
+
+	var html bytes.Buffer
+	if err := emailHTMLTemplate.Execute(&html, map[string]any{
+		"Message": n.Message,
+		"Details": details,
+		"Time":    sentAt.Format(time.RFC1123),
+	}); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text.String()},
+		{"text/html; charset=utf-8", html.String()},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writer, err := parts.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(writer)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", emailSubject(n)))
+	fmt.Fprintf(&message, "Date: %s\r\n", sentAt.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
+
+func emailSubject(n Notification) string {
+	if hasCustomTitle(n) {
+		return n.Title
+	}
+	if n.Kind == notificationDigest {
+		return fmt.Sprintf("%s: %d alerts", n.Title, len(n.Alerts))
+	}
+	if (n.Kind == notificationAlert || n.Kind == notificationTest) && n.Quote != nil {
+		return fmt.Sprintf("%s: %s %.2f", n.Title, n.Symbol, n.Quote.Price)
+	}
+	if n.Symbol != "" {
+		return fmt.Sprintf("%s: %s %s", n.Title, n.Symbol, n.Kind)
+	}
+	return fmt.Sprintf("%s: %s", n.Title, n.Kind)
+}
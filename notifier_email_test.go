@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer accepts one session and records the envelope and message.
+type fakeSMTPServer struct {
+	addr       string
+	extensions []string
+	done       chan struct{}
+
+	from string
+	to   []string
+	data string
+}
+
+func startFakeSMTPServer(t *testing.T, extensions ...string) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	server := &fakeSMTPServer{addr: listener.Addr().String(), extensions: extensions, done: make(chan struct{})}
+	go func() {
+		defer close(server.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		server.serve(textproto.NewConn(conn))
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn *textproto.Conn) {
+	_ = conn.PrintfLine("220 localhost fake ESMTP")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			lines := append([]string{"localhost"}, s.extensions...)
+			for i, ext := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				_ = conn.PrintfLine("250%s%s", sep, ext)
+			}
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = envelopeAddress(line[len("MAIL FROM:"):])
+			_ = conn.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, envelopeAddress(line[len("RCPT TO:"):]))
+			_ = conn.PrintfLine("250 OK")
+		case command == "DATA":
+			_ = conn.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			_ = conn.PrintfLine("250 OK")
+		case command == "QUIT":
+			_ = conn.PrintfLine("221 Bye")
+			return
+		default:
+			_ = conn.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func envelopeAddress(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if end := strings.Index(arg, ">"); end != -1 {
+		arg = arg[:end]
+	}
+	return strings.TrimPrefix(arg, "<")
+}
+
+func newTestEmailNotifier(t *testing.T, addr string, security string) Notifier {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	notifier, err := newEmailNotifier("", channelEmail, ChannelSettings{Email: &EmailConfig{
+		Host:     host,
+		Port:     portNumber,
+		Security: security,
+		From:     "alerts@example.com",
+		To:       []string{"me@example.com", "team@example.com"},
+		Timeout:  "5s",
+	}})
+	if err != nil {
+		t.Fatalf("newEmailNotifier failed: %v", err)
+	}
+	return notifier
+}
+
+func TestEmailNotifierSendsMultipartMessage(t *testing.T) {
+	server := startFakeSMTPServer(t, "8BITMIME")
+	notifier := newTestEmailNotifier(t, server.addr, emailSecurityNone)
+
+	change := -1.25
+	n := newAlertNotification("AAPL", AlertRule{Threshold: 180, Direction: directionBelow}, Quote{Symbol: "AAPL", Name: "Apple Inc", Price: 179.5, ChangePercent: &change, Source: providerStockpricesDev}, testAlertNotification().Time)
+	if err := notifier.Notify(n); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	<-server.done
+
+	if server.from != "alerts@example.com" || len(server.to) != 2 {
+		t.Fatalf("unexpected envelope: from=%q to=%v", server.from, server.to)
+	}
+	reader := bufio.NewReader(strings.NewReader(server.data))
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("invalid message header: %v", err)
+	}
+	if !strings.HasPrefix(header.Get("Content-Type"), "multipart/alternative") {
+		t.Fatalf("expected multipart/alternative, got %q", header.Get("Content-Type"))
+	}
+	if !strings.Contains(header.Get("Subject"), "AAPL 179.50") {
+		t.Fatalf("unexpected subject: %q", header.Get("Subject"))
+	}
+	for _, want := range []string{"text/plain", "text/html", "Change %: -1.25%", "Apple Inc"} {
+		if !strings.Contains(server.data, want) {
+			t.Fatalf("message missing %q:\n%s", want, server.data)
+		}
+	}
+}
+
+func TestEmailNotifierRequiresSTARTTLSSupport(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	notifier := newTestEmailNotifier(t, server.addr, emailSecurityStartTLS)
+
+	if err := notifier.Notify(testAlertNotification()); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected STARTTLS error, got: %v", err)
+	}
+}
+
+func TestEmailNotifierConfigValidation(t *testing.T) {
+	if _, err := newEmailNotifier("", channelEmail, ChannelSettings{Email: &EmailConfig{Host: "smtp.example.com"}}); err == nil {
+		t.Fatalf("expected error without from/to")
+	}
+
+	t.Setenv(defaultSMTPPasswordEnv, "")
+	cfg := &EmailConfig{Host: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}, Username: "user"}
+	if _, err := newEmailNotifier("", channelEmail, ChannelSettings{Email: cfg}); err == nil {
+		t.Fatalf("expected error when the password env var is not set")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	channelExec        = "exec"
+	defaultExecTimeout = 30 * time.Second
+	// maxExecStderr caps how much of a hook's stderr is logged.
+	maxExecStderr = 4096
+)
+
+// ExecConfig runs a command for every notification. Details are passed in
+// STOCK_* environment variables and as the webhook JSON payload on stdin.
+type ExecConfig struct {
+	// Command is run directly, not through a shell. Relative paths containing
+	// a slash are resolved against the stocks.json directory.
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+type execNotifier struct {
+	name    string
+	dir     string
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func init() {
+	registerNotifier(channelExec, newExecNotifier)
+}
+
+func newExecNotifier(dir, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Exec
+	if cfg == nil || strings.TrimSpace(cfg.Command) == "" {
+		return nil, fmt.Errorf("exec channel needs exec.command")
+	}
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultExecTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	command := strings.TrimSpace(cfg.Command)
+	if strings.ContainsRune(command, filepath.Separator) && !filepath.IsAbs(command) {
+		command = filepath.Join(dir, command)
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("exec command %q not found: %v", cfg.Command, err)
+	}
+
+	return &execNotifier{
+		name:    name,
+		dir:     dir,
+		command: command,
+		args:    cfg.Args,
+		timeout: timeout,
+	}, nil
+}
+
+func (e *execNotifier) Name() string { return e.name }
+
+func (e *execNotifier) Notify(n Notification) error {
+	stdin, err := json.Marshal(buildAlertPayload(n))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.command, e.args...)
+	cmd.Dir = e.dir
+	cmd.Env = append(os.Environ(), execEnvironment(n)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// Give the hook a moment to exit after being killed so a child still
+	// holding stderr open cannot block Notify.
+	cmd.WaitDelay = time.Second
+
+	runErr := cmd.Run()
+	output := strings.TrimSpace(stderr.String())
+	if len(output) > maxExecStderr {
+		output = output[:maxExecStderr] + "..."
+	}
+	if output != "" {
+		log.Printf("Exec hook %s stderr: %s", e.name, output)
+	}
+
+	switch {
+	case runErr == nil:
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("command timed out after %v", e.timeout)
+	default:
+		return fmt.Errorf("command failed: %v", runErr)
+	}
+}
+
+// execEnvironment lists the STOCK_* variables describing n. Values that do not
+// apply to the notification are left unset.
+func execEnvironment(n Notification) []string {
+	var env []string
+	set := func(key, value string) {
+		if value != "" {
+			env = append(env, key+"="+value)
+		}
+	}
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	set("STOCK_KIND", n.Kind)
+	set("STOCK_SYMBOL", n.Symbol)
+	set("STOCK_RULE_ID", n.RuleKey)
+	set("STOCK_MESSAGE", n.Message)
+	if !n.Time.IsZero() {
+		set("STOCK_TIME", n.Time.UTC().Format(time.RFC3339))
+	}
+	if q := n.Quote; q != nil {
+		set("STOCK_NAME", q.Name)
+		set("STOCK_PRICE", formatFloat(q.Price))
+		if q.ChangePercent != nil {
+			set("STOCK_CHANGE_PERCENT", formatFloat(*q.ChangePercent))
+		}
+		set("STOCK_SOURCE", q.Source)
+		set("STOCK_DELAYED", strconv.FormatBool(q.Delayed))
+	}
+	if r := n.Rule; r != nil {
+		set("STOCK_DIRECTION", r.Direction)
+		set("STOCK_RULE", describeRule(*r))
+		if isBandDirection(r.Direction) {
+			set("STOCK_LOWER", formatFloat(r.Lower))
+			set("STOCK_UPPER", formatFloat(r.Upper))
+		} else {
+			set("STOCK_THRESHOLD", formatFloat(r.Threshold))
+		}
+		set("STOCK_SEVERITY", notificationSeverity(n))
+	}
+	return env
+}
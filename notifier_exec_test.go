@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecNotifierPassesEnvironmentAndStdin(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"printf '%s|%s|%s|%s|%s' \"$STOCK_SYMBOL\" \"$STOCK_PRICE\" \"$STOCK_THRESHOLD\" \"$STOCK_DIRECTION\" \"$STOCK_RULE_ID\" > env.txt\n" +
+		"cat > stdin.json\n" +
+		"echo 'hook ran' >&2\n"
+	if err := os.WriteFile(filepath.Join(dir, "hook.sh"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed writing hook: %v", err)
+	}
+
+	notifier, err := newExecNotifier(dir, channelExec, ChannelSettings{Exec: &ExecConfig{Command: "./hook.sh"}})
+	if err != nil {
+		t.Fatalf("newExecNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	env, err := os.ReadFile(filepath.Join(dir, "env.txt"))
+	if err != nil {
+		t.Fatalf("hook did not write env.txt: %v", err)
+	}
+	if string(env) != "AAPL|179.5|180|below|AAPL" {
+		t.Fatalf("unexpected hook environment: %q", env)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "stdin.json"))
+	if err != nil {
+		t.Fatalf("hook did not write stdin.json: %v", err)
+	}
+	var payload alertPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		t.Fatalf("stdin was not the alert payload: %v (%s)", err, raw)
+	}
+	if payload.Symbol != "AAPL" || payload.Price == nil || *payload.Price != 179.5 {
+		t.Fatalf("unexpected stdin payload: %+v", payload)
+	}
+}
+
+func TestExecNotifierReportsFailureAndTimeout(t *testing.T) {
+	notifier, err := newExecNotifier(t.TempDir(), channelExec, ChannelSettings{Exec: &ExecConfig{Command: "sh", Args: []string{"-c", "exit 3"}}})
+	if err != nil {
+		t.Fatalf("newExecNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("expected exit status error, got: %v", err)
+	}
+
+	notifier, err = newExecNotifier(t.TempDir(), channelExec, ChannelSettings{Exec: &ExecConfig{Command: "sh", Args: []string{"-c", "sleep 5"}, Timeout: "100ms"}})
+	if err != nil {
+		t.Fatalf("newExecNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+}
+
+func TestExecNotifierRequiresCommand(t *testing.T) {
+	if _, err := newExecNotifier("", channelExec, ChannelSettings{}); err == nil || !strings.Contains(err.Error(), "exec.command") {
+		t.Fatalf("expected missing command error, got: %v", err)
+	}
+	if _, err := newExecNotifier(t.TempDir(), channelExec, ChannelSettings{Exec: &ExecConfig{Command: "./missing.sh"}}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing script error, got: %v", err)
+	}
+}
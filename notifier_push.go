@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	channelPush = "push"
+
+	pushProtocolNtfy   = "ntfy"
+	pushProtocolGotify = "gotify"
+
+	defaultNtfyServerURL   = "https://ntfy.sh"
+	defaultNtfyTokenEnv    = "STOCKS_NOTIFIER_NTFY_TOKEN"
+	defaultGotifyTokenEnv  = "STOCKS_NOTIFIER_GOTIFY_TOKEN"
+	defaultPushTimeout     = 10 * time.Second
+	gotifyClickExtrasKey   = "client::notification"
+	gotifyDisplayExtrasKey = "client::display"
+)
+
+// PushConfig sends alerts to phones through a self-hosted ntfy or Gotify
+// server.
+type PushConfig struct {
+	// Protocol is "ntfy" or "gotify".
+	Protocol string `json:"protocol"`
+	// ServerURL defaults to https://ntfy.sh for ntfy and is required for
+	// Gotify.
+	ServerURL string `json:"serverURL,omitempty"`
+	// Topic is the ntfy topic to publish to.
+	Topic string `json:"topic,omitempty"`
+	// TokenEnv names the environment variable holding the ntfy access token
+	// (optional, default STOCKS_NOTIFIER_NTFY_TOKEN) or the Gotify app token
+	// (required, default STOCKS_NOTIFIER_GOTIFY_TOKEN).
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// Tags are extra ntfy tags; emoji short codes render as icons.
+	Tags []string `json:"tags,omitempty"`
+	// ClickURL is opened when the notification is tapped, typically the
+	// web UI address.
+	ClickURL string `json:"clickURL,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
+type pushNotifier struct {
+	name      string
+	protocol  string
+	serverURL string
+	topic     string
+	token     string
+	tags      []string
+	clickURL  string
+	client    *http.Client
+}
+
+func init() {
+	registerNotifier(channelPush, newPushNotifier)
+}
+
+func newPushNotifier(_, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Push
+	if cfg == nil {
+		return nil, fmt.Errorf("push channel needs push.protocol")
+	}
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultPushTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &pushNotifier{
+		name:     name,
+		protocol: strings.ToLower(strings.TrimSpace(cfg.Protocol)),
+		tags:     cfg.Tags,
+		clickURL: strings.TrimSpace(cfg.ClickURL),
+		client:   &http.Client{Timeout: timeout},
+	}
+
+	switch notifier.protocol {
+	case pushProtocolNtfy:
+		notifier.topic = strings.TrimSpace(cfg.Topic)
+		if notifier.topic == "" {
+			return nil, fmt.Errorf("ntfy push channel needs push.topic")
+		}
+		notifier.serverURL = chatBaseURL(cfg.ServerURL, defaultNtfyServerURL)
+		// Public topics need no token, so only an explicitly named variable
+		// is required to be set.
+		if strings.TrimSpace(cfg.TokenEnv) != "" {
+			token, err := secretFromEnv(cfg.TokenEnv, defaultNtfyTokenEnv)
+			if err != nil {
+				return nil, err
+			}
+			notifier.token = token
+		} else {
+			notifier.token = strings.TrimSpace(os.Getenv(defaultNtfyTokenEnv))
+		}
+	case pushProtocolGotify:
+		notifier.serverURL = chatBaseURL(cfg.ServerURL, "")
+		if notifier.serverURL == "" {
+			return nil, fmt.Errorf("gotify push channel needs push.serverURL")
+		}
+		token, err := secretFromEnv(cfg.TokenEnv, defaultGotifyTokenEnv)
+		if err != nil {
+			return nil, err
+		}
+		notifier.token = token
+	default:
+		return nil, fmt.Errorf("unsupported push protocol %q (supported: %q, %q)", cfg.Protocol, pushProtocolNtfy, pushProtocolGotify)
+	}
+
+	return notifier, nil
+}
+
+func (p *pushNotifier) Name() string { return p.name }
+
+func (p *pushNotifier) Notify(n Notification) error {
+	if p.protocol == pushProtocolGotify {
+		return p.notifyGotify(n)
+	}
+	return p.notifyNtfy(n)
+}
+
+func (p *pushNotifier) notifyNtfy(n Notification) error {
+	message := map[string]any{
+		"topic":    p.topic,
+		"title":    chatHeadline(n),
+		"message":  n.Message,
+		"priority": ntfyPriority(notificationSeverity(n)),
+	}
+	if tags := p.ntfyTags(n); len(tags) > 0 {
+		message["tags"] = tags
+	}
+	if p.clickURL != "" {
+		message["click"] = p.clickURL
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var headers map[string]string
+	if p.token != "" {
+		headers = map[string]string{"Authorization": "Bearer " + p.token}
+	}
+	// ntfy accepts JSON publishes on the server root; the topic is in the body.
+	return postJSON(p.client, p.serverURL+"/", headers, body)
+}
+
+func (p *pushNotifier) notifyGotify(n Notification) error {
+	extras := map[string]any{
+		gotifyDisplayExtrasKey: map[string]any{"contentType": "text/plain"},
+	}
+	if p.clickURL != "" {
+		extras[gotifyClickExtrasKey] = map[string]any{
+			"click": map[string]any{"url": p.clickURL},
+		}
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"title":    chatHeadline(n),
+		"message":  n.Message,
+		"priority": gotifyPriority(notificationSeverity(n)),
+		"extras":   extras,
+	})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-Gotify-Key": p.token}
+	return postJSON(p.client, p.serverURL+"/message", headers, body)
+}
+
+// ntfyTags adds a direction emoji ahead of the configured tags.
+func (p *pushNotifier) ntfyTags(n Notification) []string {
+	var tags []string
+	switch {
+	case n.Kind == notificationError:
+		tags = append(tags, "warning")
+	case n.Kind == notificationRecovered:
+		tags = append(tags, "white_check_mark")
+	case n.Rule != nil && (n.Rule.Direction == directionAbove || n.Rule.Direction == directionChangePctAbove):
+		tags = append(tags, "chart_with_upwards_trend")
+	case n.Rule != nil:
+		tags = append(tags, "chart_with_downwards_trend")
+	}
+	for _, tag := range p.tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if n.Symbol != "" {
+		tags = append(tags, n.Symbol)
+	}
+	return tags
+}
+
+// notificationSeverity is the rule severity for alerts. Errors are sent at
+// high severity so a broken monitor is not missed.
+func notificationSeverity(n Notification) string {
+	if n.Kind == notificationError {
+		return severityHigh
+	}
+	if n.Kind == notificationDigest {
+		highest := severityLow
+		for _, alert := range n.Alerts {
+			if severity := notificationSeverity(alert); severityRank(severity) > severityRank(highest) {
+				highest = severity
+			}
+		}
+		return highest
+	}
+	if n.Rule == nil || n.Rule.Severity == "" {
+		return severityDefault
+	}
+	return n.Rule.Severity
+}
+
+func severityRank(severity string) int {
+	switch severity {
+	case severityLow:
+		return 0
+	case severityHigh:
+		return 2
+	case severityUrgent:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// ntfyPriority maps a severity onto ntfy's 1-5 priority scale.
+func ntfyPriority(severity string) int {
+	switch severity {
+	case severityLow:
+		return 2
+	case severityHigh:
+		return 4
+	case severityUrgent:
+		return 5
+	default:
+		return 3
+	}
+}
+
+// gotifyPriority maps a severity onto Gotify's 0-10 priority scale. Android
+// clients only play a sound from priority 4 and pop up from priority 8.
+func gotifyPriority(severity string) int {
+	switch severity {
+	case severityLow:
+		return 2
+	case severityHigh:
+		return 8
+	case severityUrgent:
+		return 10
+	default:
+		return 5
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNtfyPushNotifierPublishesJSON(t *testing.T) {
+	t.Setenv("NTFY_TEST_TOKEN", "tk_secret")
+	server, captured := startChatServer(t, `{"id": "abc"}`)
+
+	notifier, err := newPushNotifier("", channelPush, ChannelSettings{Push: &PushConfig{
+		Protocol:  "ntfy",
+		ServerURL: server.URL,
+		Topic:     "stocks",
+		TokenEnv:  "NTFY_TEST_TOKEN",
+		Tags:      []string{"moneybag"},
+		ClickURL:  "http://nas.local:8080/",
+	}})
+	if err != nil {
+		t.Fatalf("newPushNotifier failed: %v", err)
+	}
+
+	n := testAlertNotification()
+	n.Rule.Severity = severityUrgent
+	if err := notifier.Notify(n); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if captured.path != "/" || captured.headers.Get("Authorization") != "Bearer tk_secret" {
+		t.Fatalf("unexpected request: path=%q auth=%q", captured.path, captured.headers.Get("Authorization"))
+	}
+	if captured.body["topic"] != "stocks" || captured.body["priority"] != float64(5) || captured.body["click"] != "http://nas.local:8080/" {
+		t.Fatalf("unexpected ntfy payload: %#v", captured.body)
+	}
+	tags, _ := captured.body["tags"].([]any)
+	if len(tags) != 3 || tags[0] != "chart_with_downwards_trend" || tags[1] != "moneybag" || tags[2] != "AAPL" {
+		t.Fatalf("unexpected ntfy tags: %#v", tags)
+	}
+}
+
+func TestGotifyPushNotifierSendsAppToken(t *testing.T) {
+	t.Setenv(defaultGotifyTokenEnv, "app-token")
+	server, captured := startChatServer(t, `{"id": 1}`)
+
+	notifier, err := newPushNotifier("", channelPush, ChannelSettings{Push: &PushConfig{
+		Protocol:  "gotify",
+		ServerURL: server.URL + "/",
+		ClickURL:  "http://nas.local:8080/",
+	}})
+	if err != nil {
+		t.Fatalf("newPushNotifier failed: %v", err)
+	}
+	if err := notifier.Notify(testAlertNotification()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if captured.path != "/message" || captured.headers.Get("X-Gotify-Key") != "app-token" {
+		t.Fatalf("unexpected request: path=%q key=%q", captured.path, captured.headers.Get("X-Gotify-Key"))
+	}
+	if captured.body["priority"] != float64(5) || captured.body["title"] != "AAPL alert" {
+		t.Fatalf("unexpected gotify payload: %#v", captured.body)
+	}
+	extras, _ := captured.body["extras"].(map[string]any)
+	if _, ok := extras[gotifyClickExtrasKey]; !ok {
+		t.Fatalf("click URL missing from gotify extras: %#v", extras)
+	}
+}
+
+func TestPushPriorityFromSeverity(t *testing.T) {
+	cases := []struct {
+		severity string
+		ntfy     int
+		gotify   int
+	}{
+		{severityLow, 2, 2},
+		{severityDefault, 3, 5},
+		{severityHigh, 4, 8},
+		{severityUrgent, 5, 10},
+	}
+	for _, tc := range cases {
+		if got := ntfyPriority(tc.severity); got != tc.ntfy {
+			t.Fatalf("ntfyPriority(%q) = %d, want %d", tc.severity, got, tc.ntfy)
+		}
+		if got := gotifyPriority(tc.severity); got != tc.gotify {
+			t.Fatalf("gotifyPriority(%q) = %d, want %d", tc.severity, got, tc.gotify)
+		}
+	}
+
+	errorNotification := newErrorNotification("AAPL", errors.New("boom"), testAlertNotification().Time)
+	if got := notificationSeverity(errorNotification); got != severityHigh {
+		t.Fatalf("expected errors to be sent at high severity, got %q", got)
+	}
+}
+
+func TestPushNotifierValidatesConfig(t *testing.T) {
+	t.Setenv(defaultGotifyTokenEnv, "")
+	cases := []struct {
+		cfg  *PushConfig
+		want string
+	}{
+		{nil, "push.protocol"},
+		{&PushConfig{Protocol: "pushover"}, "unsupported push protocol"},
+		{&PushConfig{Protocol: "ntfy"}, "push.topic"},
+		{&PushConfig{Protocol: "gotify"}, "push.serverURL"},
+		{&PushConfig{Protocol: "gotify", ServerURL: "http://gotify.local"}, defaultGotifyTokenEnv},
+	}
+	for _, tc := range cases {
+		_, err := newPushNotifier("", channelPush, ChannelSettings{Push: tc.cfg})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected error containing %q for %#v, got: %v", tc.want, tc.cfg, err)
+		}
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	channelWebhook         = "webhook"
+	webhookOutboxFile      = ".stocks-notifier-outbox.json"
+	webhookSignatureHeader = "X-Stocks-Notifier-Signature"
+	defaultWebhookTimeout  = 10 * time.Second
+	defaultWebhookBackoff  = 2 * time.Second
+	// unlimitedWebhookRetries keeps a queued delivery until it succeeds or is
+	// pushed out of a full outbox.
+	unlimitedWebhookRetries = -1
+	maxOutboxBackoff        = time.Hour
+	maxOutboxEntries        = 500
+)
+
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Header values may reference environment variables, e.g. "Bearer ${TOKEN}".
+	Headers map[string]string `json:"headers,omitempty"`
+	// SecretEnv names the environment variable holding the HMAC-SHA256
+	// signing secret; the signature is sent in X-Stocks-Notifier-Signature.
+	SecretEnv string `json:"secretEnv,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+	// MaxRetries caps how often a queued delivery is retried before it is
+	// dropped; by default it is retried until delivered. Zero disables the
+	// outbox.
+	MaxRetries *int `json:"maxRetries,omitempty"`
+}
+
+// alertPayload is the JSON document sent to webhooks and exec hooks.
+type alertPayload struct {
+	Kind      string     `json:"kind"`
+	Symbol    string     `json:"symbol,omitempty"`
+	RuleID    string     `json:"ruleId,omitempty"`
+	Price     *float64   `json:"price,omitempty"`
+	Direction string     `json:"direction,omitempty"`
+	Threshold *float64   `json:"threshold,omitempty"`
+	Rule      *AlertRule `json:"rule,omitempty"`
+	Quote     *Quote     `json:"quote,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	Message   string     `json:"message"`
+	// ErrorClass is set on error and recovered notifications.
+	ErrorClass string `json:"errorClass,omitempty"`
+	// Alerts lists the individual alerts of a digest.
+	Alerts []alertPayload `json:"alerts,omitempty"`
+}
+
+func buildAlertPayload(n Notification) alertPayload {
+	payload := alertPayload{
+		Kind:       n.Kind,
+		Symbol:     n.Symbol,
+		RuleID:     n.RuleKey,
+		Rule:       n.Rule,
+		Quote:      n.Quote,
+		Timestamp:  n.Time,
+		Message:    n.Message,
+		ErrorClass: n.ErrorClass,
+	}
+	if n.Quote != nil {
+		price := n.Quote.Price
+		payload.Price = &price
+	}
+	if n.Rule != nil {
+		threshold := n.Rule.Threshold
+		payload.Direction = n.Rule.Direction
+		payload.Threshold = &threshold
+	}
+	for _, alert := range n.Alerts {
+		payload.Alerts = append(payload.Alerts, buildAlertPayload(alert))
+	}
+	return payload
+}
+
+// permanentError marks a delivery failure that retrying will not fix, such as
+// a 4xx response to a malformed request.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func postJSON(client *http.Client, url string, headers map[string]string, body []byte) error {
+	return postJSONDecode(client, url, headers, body, nil)
+}
+
+// postJSONDecode is postJSON for APIs that report errors in the response
+// body; a successful response is decoded into out when out is not nil.
+func postJSONDecode(client *http.Client, url string, headers map[string]string, body []byte, out any) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{fmt.Errorf("failed to build request: %v", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "stocks-notifier/1.0")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		msg := strings.TrimSpace(string(respBody))
+		if msg == "" {
+			msg = resp.Status
+		}
+		err := fmt.Errorf("unexpected status %d: %s", resp.StatusCode, msg)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return permanentError{err}
+		}
+		return err
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return nil
+}
+
+func parseChannelTimeout(raw string, defaultValue time.Duration) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultValue, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", raw)
+	}
+	return timeout, nil
+}
+
+// secretFromEnv reads a credential from the named environment variable,
+// falling back to defaultEnv when no name is configured.
+func secretFromEnv(envKey, defaultEnv string) (string, error) {
+	envKey = strings.TrimSpace(envKey)
+	if envKey == "" {
+		envKey = defaultEnv
+	}
+	if envKey == "" {
+		return "", nil
+	}
+	value := strings.TrimSpace(os.Getenv(envKey))
+	if value == "" {
+		return "", fmt.Errorf("environment variable %s is not set", envKey)
+	}
+	return value, nil
+}
+
+type webhookNotifier struct {
+	name       string
+	url        string
+	key        string
+	headers    map[string]string
+	secret     []byte
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+	outboxPath string
+	now        func() time.Time
+}
+
+func init() {
+	registerNotifier(channelWebhook, newWebhookNotifier)
+}
+
+func newWebhookNotifier(dir, name string, channel ChannelSettings) (Notifier, error) {
+	cfg := channel.Webhook
+	if cfg == nil || strings.TrimSpace(cfg.URL) == "" {
+		return nil, fmt.Errorf("webhook channel needs webhook.url")
+	}
+
+	timeout, err := parseChannelTimeout(cfg.Timeout, defaultWebhookTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &webhookNotifier{
+		name:       name,
+		url:        strings.TrimSpace(cfg.URL),
+		key:        webhookOutboxKey(*cfg),
+		headers:    make(map[string]string, len(cfg.Headers)),
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: unlimitedWebhookRetries,
+		backoff:    defaultWebhookBackoff,
+		outboxPath: filepath.Join(dir, webhookOutboxFile),
+		now:        time.Now,
+	}
+	if cfg.MaxRetries != nil {
+		if *cfg.MaxRetries < 0 {
+			return nil, fmt.Errorf("maxRetries cannot be negative")
+		}
+		notifier.maxRetries = *cfg.MaxRetries
+	}
+	for key, value := range cfg.Headers {
+		notifier.headers[key] = os.ExpandEnv(value)
+	}
+	if cfg.SecretEnv != "" {
+		secret, err := secretFromEnv(cfg.SecretEnv, "")
+		if err != nil {
+			return nil, err
+		}
+		notifier.secret = []byte(secret)
+	}
+
+	return notifier, nil
+}
+
+func (w *webhookNotifier) Name() string { return w.name }
+
+// Notify makes one delivery attempt. A transient failure queues the body in
+// the outbox, where FlushOutbox retries it with exponential backoff, so a
+// slow or failing endpoint never holds up the poll cycle.
+func (w *webhookNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(buildAlertPayload(n))
+	if err != nil {
+		return err
+	}
+
+	err = w.deliver(body)
+	if err == nil {
+		return nil
+	}
+	var permanent permanentError
+	if errors.As(err, &permanent) || w.maxRetries == 0 || n.Kind == notificationTest {
+		return err
+	}
+
+	if queueErr := w.enqueue(body); queueErr != nil {
+		return fmt.Errorf("%v; failed to queue for retry: %v", err, queueErr)
+	}
+	return fmt.Errorf("%v; queued for retry", err)
+}
+
+func (w *webhookNotifier) deliver(body []byte) error {
+	headers := w.headers
+	if len(w.secret) > 0 {
+		headers = make(map[string]string, len(w.headers)+1)
+		for key, value := range w.headers {
+			headers[key] = value
+		}
+		headers[webhookSignatureHeader] = signWebhookBody(w.secret, body)
+	}
+	return postJSON(w.client, w.url, headers, body)
+}
+
+// webhookOutboxKey identifies a webhook channel by where and how it delivers.
+// Channels that only differ in name share a key; the headers and secret
+// variable are hashed so that the outbox never holds their values.
+func webhookOutboxKey(cfg WebhookConfig) string {
+	url := strings.TrimSpace(cfg.URL)
+	secretEnv := strings.TrimSpace(cfg.SecretEnv)
+	if secretEnv == "" && len(cfg.Headers) == 0 {
+		return url
+	}
+
+	names := make([]string, 0, len(cfg.Headers))
+	for name := range cfg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", url, secretEnv)
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s: %s\n", name, cfg.Headers[name])
+	}
+	return url + "#" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func signWebhookBody(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// outboxEntry is a queued delivery. Entries belong to the channel with the
+// same key, so renaming a channel keeps its queue while a channel that posts
+// to the same URL with other headers or another secret does not take it;
+// Channel is the name it was queued under, for logs.
+type outboxEntry struct {
+	Channel         string          `json:"channel"`
+	Key             string          `json:"key"`
+	Body            json.RawMessage `json:"body"`
+	Attempts        int             `json:"attempts"`
+	CreatedUnix     int64           `json:"created_unix"`
+	NextAttemptUnix int64           `json:"next_attempt_unix"`
+}
+
+// outboxMu serialises access to the outbox file shared by all webhook channels.
+var outboxMu sync.Mutex
+
+func (w *webhookNotifier) enqueue(body []byte) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := readOutbox(w.outboxPath)
+	if err != nil {
+		return err
+	}
+
+	now := w.now()
+	entries = append(entries, outboxEntry{
+		Channel:         w.name,
+		Key:             w.key,
+		Body:            body,
+		Attempts:        1,
+		CreatedUnix:     now.Unix(),
+		NextAttemptUnix: now.Add(w.backoff).Unix(),
+	})
+	if len(entries) > maxOutboxEntries {
+		log.Printf("Webhook outbox full, dropping %d oldest entries", len(entries)-maxOutboxEntries)
+		entries = entries[len(entries)-maxOutboxEntries:]
+	}
+	return writeOutbox(w.outboxPath, entries)
+}
+
+// FlushOutbox retries this channel's queued deliveries that are due. channels
+// maps the name of every configured channel with an outbox to its outbox key;
+// entries whose channel is no longer configured are dropped. The outbox lock
+// is not held while delivering, so other channels can queue in the meantime.
+// The first transient failure ends the flush and defers the remaining entries
+// along with it, so an endpoint that is down costs one timeout per poll.
+func (w *webhookNotifier) FlushOutbox(channels map[string]string) error {
+	due, err := w.takeDueEntries(channels)
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	now := w.now()
+	results := make([]outboxEntry, len(due))
+	done := make([]bool, len(due))
+	delivered := 0
+	for i, entry := range due {
+		err := w.deliver(entry.Body)
+		if err == nil {
+			delivered++
+			done[i] = true
+			continue
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			log.Printf("Dropping queued webhook delivery for %s: %v", w.name, err)
+			done[i] = true
+			continue
+		}
+
+		backoff := w.backoff << entry.Attempts
+		if backoff <= 0 || backoff > maxOutboxBackoff {
+			backoff = maxOutboxBackoff
+		}
+		nextAttempt := now.Add(backoff).Unix()
+		// Attempts counts the first delivery, so it is also the number of
+		// retries made once this one has failed.
+		if w.maxRetries != unlimitedWebhookRetries && entry.Attempts >= w.maxRetries {
+			log.Printf("Dropping queued webhook delivery for %s after %d retries: %v", w.name, entry.Attempts, err)
+			done[i] = true
+		} else {
+			entry.Attempts++
+			entry.NextAttemptUnix = nextAttempt
+			results[i] = entry
+		}
+
+		// The rest were not attempted, so they keep their attempt count.
+		for j := i + 1; j < len(due); j++ {
+			results[j] = due[j]
+			results[j].NextAttemptUnix = nextAttempt
+		}
+		if deferred := len(due) - i - 1; deferred > 0 {
+			log.Printf("Webhook %s is failing, deferring %d queued deliveries: %v", w.name, deferred, err)
+		}
+		break
+	}
+
+	if delivered > 0 {
+		log.Printf("Delivered %d queued webhook notifications via %s", delivered, w.name)
+	}
+	return w.recordResults(due, results, done)
+}
+
+// takeDueEntries drops orphaned entries from the outbox and returns this
+// channel's entries that are due.
+func (w *webhookNotifier) takeDueEntries(channels map[string]string) ([]outboxEntry, error) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := readOutbox(w.outboxPath)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	active := make(map[string]bool, len(channels))
+	for _, key := range channels {
+		active[key] = true
+	}
+	now := w.now()
+	changed := false
+	kept := entries[:0]
+	var due []outboxEntry
+	for _, entry := range entries {
+		if !active[entry.Key] {
+			log.Printf("Dropping queued webhook delivery for %s: channel no longer configured", entry.Channel)
+			changed = true
+			continue
+		}
+		kept = append(kept, entry)
+		if entry.Key == w.key && entry.NextAttemptUnix <= now.Unix() {
+			due = append(due, entry)
+		}
+	}
+	if changed {
+		if err := writeOutbox(w.outboxPath, kept); err != nil {
+			return nil, err
+		}
+	}
+	return due, nil
+}
+
+// recordResults applies the outcome of a flush to the outbox as it is now,
+// keeping anything queued while the deliveries were in flight.
+func (w *webhookNotifier) recordResults(due, results []outboxEntry, done []bool) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	entries, err := readOutbox(w.outboxPath)
+	if err != nil {
+		return err
+	}
+	applied := make([]bool, len(due))
+	remaining := entries[:0]
+	for _, entry := range entries {
+		matched := -1
+		for i := range due {
+			if !applied[i] && sameOutboxEntry(entry, due[i]) {
+				matched = i
+				break
+			}
+		}
+		if matched < 0 {
+			remaining = append(remaining, entry)
+			continue
+		}
+		applied[matched] = true
+		if !done[matched] {
+			remaining = append(remaining, results[matched])
+		}
+	}
+	return writeOutbox(w.outboxPath, remaining)
+}
+
+func sameOutboxEntry(a, b outboxEntry) bool {
+	return a.Key == b.Key && a.CreatedUnix == b.CreatedUnix && bytes.Equal(a.Body, b.Body)
+}
+
+// outboxKey identifies this channel's entries in the outbox.
+func (w *webhookNotifier) outboxKey() string { return w.key }
+
+// outboxFlusher is implemented by channels that queue failed deliveries.
+type outboxFlusher interface {
+	outboxKey() string
+	FlushOutbox(channels map[string]string) error
+}
+
+func flushOutboxes(notifiers []Notifier) {
+	channels := make(map[string]string)
+	var flushers []Notifier
+	for _, notifier := range notifiers {
+		flusher, ok := notifier.(outboxFlusher)
+		if !ok || flusher.outboxKey() == "" {
+			continue
+		}
+		channels[notifier.Name()] = flusher.outboxKey()
+		flushers = append(flushers, notifier)
+	}
+
+	for _, notifier := range flushers {
+		if err := notifier.(outboxFlusher).FlushOutbox(channels); err != nil {
+			log.Printf("Failed to flush outbox for %s: %v", notifier.Name(), err)
+		}
+	}
+}
+
+// nextOutboxAttempt reports when the earliest queued delivery of the given
+// channels is due, so the monitor can retry it while it sleeps between polls.
+func nextOutboxAttempt(dir string, notifiers []Notifier) (time.Time, bool) {
+	keys := make(map[string]bool)
+	for _, notifier := range notifiers {
+		if flusher, ok := notifier.(outboxFlusher); ok && flusher.outboxKey() != "" {
+			keys[flusher.outboxKey()] = true
+		}
+	}
+	if len(keys) == 0 {
+		return time.Time{}, false
+	}
+
+	outboxMu.Lock()
+	entries, err := readOutbox(filepath.Join(dir, webhookOutboxFile))
+	outboxMu.Unlock()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var next int64
+	found := false
+	for _, entry := range entries {
+		if !keys[entry.Key] {
+			continue
+		}
+		if !found || entry.NextAttemptUnix < next {
+			next, found = entry.NextAttemptUnix, true
+		}
+	}
+	return time.Unix(next, 0), found
+}
+
+func readOutbox(path string) ([]outboxEntry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+
+	var entries []outboxEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("invalid outbox file: %v", err)
+	}
+	return entries, nil
+}
+
+func writeOutbox(path string, entries []outboxEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(tmpFile)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
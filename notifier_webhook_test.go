@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhookNotifier(t *testing.T, dir string, cfg WebhookConfig) *webhookNotifier {
+	t.Helper()
+	notifier, err := newWebhookNotifier(dir, channelWebhook, ChannelSettings{Type: channelWebhook, Webhook: &cfg})
+	if err != nil {
+		t.Fatalf("newWebhookNotifier failed: %v", err)
+	}
+	webhook := notifier.(*webhookNotifier)
+	webhook.backoff = time.Millisecond
+	return webhook
+}
+
+func testAlertNotification() Notification {
+	quote := Quote{Symbol: "AAPL", Price: 179.5, Source: providerStockpricesDev}
+	return newAlertNotification("AAPL", AlertRule{Threshold: 180, Direction: directionBelow}, quote, time.Unix(1_700_000_000, 0).UTC())
+}
+
+func TestWebhookNotifierSendsSignedPayload(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_SECRET", "s3cret")
+	t.Setenv("TEST_WEBHOOK_TOKEN", "abc")
+
+	var gotPayload alertPayload
+	var gotSignature, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(webhookSignatureHeader)
+		gotAuth = r.Header.Get("Authorization")
+		if gotSignature != signWebhookBody([]byte("s3cret"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.Unmarshal(body, &gotPayload)
+	}))
+	defer server.Close()
+
+	webhook := newTestWebhookNotifier(t, t.TempDir(), WebhookConfig{
+		URL:       server.URL,
+		Headers:   map[string]string{"Authorization": "Bearer ${TEST_WEBHOOK_TOKEN}"},
+		SecretEnv: "TEST_WEBHOOK_SECRET",
+	})
+
+	if err := webhook.Notify(testAlertNotification()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if gotAuth != "Bearer abc" {
+		t.Fatalf("custom header not expanded: %q", gotAuth)
+	}
+	if gotPayload.Symbol != "AAPL" || gotPayload.Price == nil || *gotPayload.Price != 179.5 {
+		t.Fatalf("unexpected payload: %#v", gotPayload)
+	}
+	if gotPayload.Direction != directionBelow || gotPayload.Threshold == nil || *gotPayload.Threshold != 180 {
+		t.Fatalf("rule details missing from payload: %#v", gotPayload)
+	}
+}
+
+func TestWebhookNotifierQueuesThenFlushes(t *testing.T) {
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	retries := 2
+	webhook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL, MaxRetries: &retries})
+
+	if err := webhook.Notify(testAlertNotification()); err == nil {
+		t.Fatalf("expected delivery error while endpoint is down")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("expected one attempt before queueing, got %d", got)
+	}
+
+	entries, err := readOutbox(filepath.Join(dir, webhookOutboxFile))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one queued delivery, got %d (err=%v)", len(entries), err)
+	}
+
+	webhook.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if err := webhook.FlushOutbox(map[string]string{webhook.name: webhook.outboxKey()}); err != nil {
+		t.Fatalf("FlushOutbox failed: %v", err)
+	}
+	entries, err = readOutbox(filepath.Join(dir, webhookOutboxFile))
+	if err != nil || len(entries) != 1 || entries[0].Attempts != 2 {
+		t.Fatalf("expected the failed retry to stay queued, got %+v (err=%v)", entries, err)
+	}
+
+	healthy.Store(true)
+	webhook.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if err := webhook.FlushOutbox(map[string]string{webhook.name: webhook.outboxKey()}); err != nil {
+		t.Fatalf("FlushOutbox failed: %v", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("expected queued delivery to be retried once, got %d requests", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, webhookOutboxFile)); !os.IsNotExist(err) {
+		t.Fatalf("outbox should be removed once empty, stat err: %v", err)
+	}
+}
+
+func TestWebhookNotifierDropsAfterMaxRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	retries := 1
+	webhook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL, MaxRetries: &retries})
+	webhook.now = func() time.Time { return time.Now().Add(time.Minute) }
+	_ = webhook.Notify(testAlertNotification())
+	flushOutboxes([]Notifier{webhook})
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("expected one attempt and one retry, got %d", got)
+	}
+	if entries, _ := readOutbox(filepath.Join(dir, webhookOutboxFile)); len(entries) != 0 {
+		t.Fatalf("expected the delivery to be dropped after its retries: %#v", entries)
+	}
+
+	webhook.maxRetries = 0
+	if err := webhook.Notify(testAlertNotification()); err == nil {
+		t.Fatalf("expected delivery error")
+	}
+	if entries, _ := readOutbox(filepath.Join(dir, webhookOutboxFile)); len(entries) != 0 {
+		t.Fatalf("maxRetries 0 should not queue: %#v", entries)
+	}
+}
+
+func TestWebhookFlushStopsAtFirstTransientFailure(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	webhook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL})
+	webhook.backoff = time.Second
+	for i := 0; i < 5; i++ {
+		_ = webhook.Notify(testAlertNotification())
+	}
+	requests.Store(0)
+
+	now := time.Now().Add(time.Minute)
+	webhook.now = func() time.Time { return now }
+	if err := webhook.FlushOutbox(map[string]string{webhook.name: webhook.outboxKey()}); err != nil {
+		t.Fatalf("FlushOutbox failed: %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("expected the flush to stop after one failed retry, got %d requests", got)
+	}
+
+	entries, err := readOutbox(filepath.Join(dir, webhookOutboxFile))
+	if err != nil || len(entries) != 5 {
+		t.Fatalf("expected every delivery to stay queued, got %+v (err=%v)", entries, err)
+	}
+	if entries[0].Attempts != 2 || entries[1].Attempts != 1 {
+		t.Fatalf("expected only the tried delivery to count an attempt: %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.NextAttemptUnix <= now.Unix() {
+			t.Fatalf("expected every delivery to be pushed back: %+v", entries)
+		}
+	}
+}
+
+func TestWebhookOutboxFollowsRenamesAndDropsRemovedChannels(t *testing.T) {
+	var requests atomic.Int32
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	old := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL})
+	old.name = "old"
+	removed := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL + "/removed"})
+	removed.name = "removed"
+	_ = old.Notify(testAlertNotification())
+	_ = removed.Notify(testAlertNotification())
+
+	healthy.Store(true)
+	renamed := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL})
+	renamed.name = "renamed"
+	renamed.now = func() time.Time { return time.Now().Add(time.Minute) }
+	flushOutboxes([]Notifier{renamed})
+
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("expected the renamed channel to deliver its queue, got %d requests", got)
+	}
+	if entries, _ := readOutbox(filepath.Join(dir, webhookOutboxFile)); len(entries) != 0 {
+		t.Fatalf("expected the removed channel's entry to be dropped: %#v", entries)
+	}
+}
+
+func TestWebhookOutboxKeepsChannelsWithTheSameURLApart(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_SECRET", "s3cret")
+	var requests atomic.Int32
+	var healthy atomic.Bool
+	var signed atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(webhookSignatureHeader) != "" {
+			signed.Add(1)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	plain := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL})
+	plain.name = "plain"
+	plain.now = func() time.Time { return time.Now().Add(time.Minute) }
+	signedHook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL, SecretEnv: "TEST_WEBHOOK_SECRET"})
+	signedHook.name = "signed"
+	signedHook.now = plain.now
+	if plain.outboxKey() == signedHook.outboxKey() {
+		t.Fatalf("expected channels with different secrets to have different keys")
+	}
+	_ = plain.Notify(testAlertNotification())
+
+	healthy.Store(true)
+	requests.Store(0)
+	flushOutboxes([]Notifier{plain, signedHook})
+
+	if got := requests.Load(); got != 1 || signed.Load() != 0 {
+		t.Fatalf("expected the queued delivery to be sent once, unsigned; got %d requests, %d signed", got, signed.Load())
+	}
+	if entries, _ := readOutbox(filepath.Join(dir, webhookOutboxFile)); len(entries) != 0 {
+		t.Fatalf("expected the outbox to be empty: %#v", entries)
+	}
+}
+
+func TestWebhookFlushDoesNotBlockQueueing(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 1)
+	var slowHealthy atomic.Bool
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slowHealthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer slow.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	dir := t.TempDir()
+	slowHook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: slow.URL})
+	slowHook.name = "slow"
+	slowHook.now = func() time.Time { return time.Now().Add(time.Minute) }
+	downHook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: down.URL})
+	downHook.name = "down"
+	_ = slowHook.Notify(testAlertNotification())
+
+	slowHealthy.Store(true)
+	flushed := make(chan struct{})
+	go func() {
+		flushOutboxes([]Notifier{slowHook, downHook})
+		close(flushed)
+	}()
+	<-arrived
+
+	queued := make(chan struct{})
+	go func() {
+		_ = downHook.Notify(testAlertNotification())
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("queueing blocked while another channel was flushing")
+	}
+	close(release)
+	<-flushed
+
+	entries, err := readOutbox(filepath.Join(dir, webhookOutboxFile))
+	if err != nil || len(entries) != 1 || entries[0].Channel != "down" {
+		t.Fatalf("expected only the entry queued during the flush to remain, got %+v (err=%v)", entries, err)
+	}
+}
+
+func TestWebhookNotifierDoesNotRetryClientErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	webhook := newTestWebhookNotifier(t, dir, WebhookConfig{URL: server.URL})
+
+	if err := webhook.Notify(testAlertNotification()); err == nil {
+		t.Fatalf("expected client error")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("client errors should not be retried, got %d requests", got)
+	}
+	if entries, _ := readOutbox(filepath.Join(dir, webhookOutboxFile)); len(entries) != 0 {
+		t.Fatalf("client errors should not be queued: %#v", entries)
+	}
+}
+
+func TestWebhookNotifierRequiresSecretEnv(t *testing.T) {
+	cfg := WebhookConfig{URL: "http://127.0.0.1", SecretEnv: "TEST_WEBHOOK_MISSING_SECRET"}
+	if _, err := newWebhookNotifier(t.TempDir(), channelWebhook, ChannelSettings{Webhook: &cfg}); err == nil {
+		t.Fatalf("expected error when the secret env var is not set")
+	}
+}
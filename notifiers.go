@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/beeep"
+)
+
+const (
+	notificationAlert = "alert"
+	notificationError = "error"
+	// notificationTest is a sample alert sent by test-notify. Channels format
+	// it like an alert with testNotificationPrefix ahead of its message, but
+	// it is never queued for a later retry.
+	notificationTest = "test"
+
+	channelDesktop = "desktop"
+	channelLog     = "log"
+
+	notificationTitle = "Stock notifier"
+
+	testNotificationPrefix = "Test notification: "
+)
+
+// Notification is a single message delivered to every configured channel.
+// Alert notifications carry the rule and quote that triggered them.
+type Notification struct {
+	Kind    string
+	Title   string
+	Message string
+	Symbol  string
+	RuleKey string
+	Rule    *AlertRule
+	Quote   *Quote
+	// Error is the failure text of error notifications and ErrorClass its
+	// class (config, provider_down or unknown_symbol).
+	Error      string
+	ErrorClass string
+	// Alerts holds the individual alerts of a digest notification.
+	Alerts []Notification
+	Time   time.Time
+}
+
+// markTestNotification puts testNotificationPrefix ahead of the message of a
+// test notification, once, so every channel shows it is not a real alert.
+func markTestNotification(n Notification) Notification {
+	if n.Kind == notificationTest && !strings.HasPrefix(n.Message, testNotificationPrefix) {
+		n.Message = testNotificationPrefix + n.Message
+	}
+	return n
+}
+
+// Notifier delivers notifications to one channel, such as the desktop or a
+// log file.
+type Notifier interface {
+	Name() string
+	Notify(n Notification) error
+}
+
+// ChannelSettings configures one delivery channel in the settings file. Type
+// selects the backend and the matching nested block holds its options.
+type ChannelSettings struct {
+	Type     string            `json:"type"`
+	Name     string            `json:"name,omitempty"`
+	Log      *LogChannelConfig `json:"log,omitempty"`
+	Webhook  *WebhookConfig    `json:"webhook,omitempty"`
+	Email    *EmailConfig      `json:"email,omitempty"`
+	Slack    *SlackConfig      `json:"slack,omitempty"`
+	Discord  *DiscordConfig    `json:"discord,omitempty"`
+	Telegram *TelegramConfig   `json:"telegram,omitempty"`
+	Push     *PushConfig       `json:"push,omitempty"`
+	Exec     *ExecConfig       `json:"exec,omitempty"`
+	// Templates override the global message templates for this channel.
+	Templates *MessageTemplates `json:"templates,omitempty"`
+}
+
+type LogChannelConfig struct {
+	// Path is resolved relative to the stocks.json directory.
+	Path string `json:"path"`
+}
+
+type notifierFactory func(dir, name string, channel ChannelSettings) (Notifier, error)
+
+var notifierFactories = map[string]notifierFactory{}
+
+func registerNotifier(channelType string, factory notifierFactory) {
+	notifierFactories[channelType] = factory
+}
+
+func init() {
+	registerNotifier(channelDesktop, newDesktopNotifier)
+	registerNotifier(channelLog, newLogNotifier)
+}
+
+// buildNotifiers creates the configured channels, defaulting to desktop
+// notifications when none are configured. Channels with message templates,
+// global or their own, are wrapped to render them.
+func buildNotifiers(dir string, channels []ChannelSettings, templates *MessageTemplates) ([]Notifier, error) {
+	if len(channels) == 0 {
+		channels = []ChannelSettings{{Type: channelDesktop}}
+	}
+
+	notifiers := make([]Notifier, 0, len(channels))
+	names := make(map[string]int, len(channels))
+	for i, channel := range channels {
+		channelType := strings.ToLower(strings.TrimSpace(channel.Type))
+		factory, ok := notifierFactories[channelType]
+		if !ok {
+			return nil, fmt.Errorf("channel %d: unknown type %q", i+1, channel.Type)
+		}
+
+		name := strings.TrimSpace(channel.Name)
+		if name == "" {
+			name = channelType
+		}
+		names[name]++
+		if names[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, names[name])
+		}
+
+		notifier, err := factory(dir, name, channel)
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %v", name, err)
+		}
+		compiled, err := compileTemplates(mergeTemplates(templates, channel.Templates))
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %v", name, err)
+		}
+		if compiled != nil {
+			notifier = &templatedNotifier{Notifier: notifier, templates: compiled}
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	return notifiers, nil
+}
+
+// dispatchNotification sends n to every channel concurrently so a slow or
+// failing channel does not hold up the others, and logs each result.
+func dispatchNotification(notifiers []Notifier, n Notification) {
+	var wg sync.WaitGroup
+	for _, notifier := range notifiers {
+		wg.Add(1)
+		go func(notifier Notifier) {
+			defer wg.Done()
+			if err := notifier.Notify(n); err != nil {
+				log.Printf("Notification via %s failed: %v", notifier.Name(), err)
+				return
+			}
+			log.Printf("Notification sent via %s", notifier.Name())
+		}(notifier)
+	}
+	wg.Wait()
+}
+
+func newAlertNotification(symbol string, rule AlertRule, quote Quote, now time.Time) Notification {
+	return Notification{
+		Kind:    notificationAlert,
+		Title:   notificationTitle,
+		Message: fmt.Sprintf("Price of stock %v: %s (target %s)", displaySymbol(quote), formatQuoteSummary(quote), describeRule(rule)),
+		Symbol:  symbol,
+		RuleKey: ruleKey(symbol, rule),
+		Rule:    &rule,
+		Quote:   &quote,
+		Time:    now,
+	}
+}
+
+func newErrorNotification(symbol string, err error, now time.Time) Notification {
+	return Notification{
+		Kind:    notificationError,
+		Title:   notificationTitle,
+		Message: fmt.Sprintf("Error: %v", err),
+		Symbol:  symbol,
+		Error:   err.Error(),
+		Time:    now,
+	}
+}
+
+// queuedNotifier is implemented by channels that deliver some notifications
+// in the background after Notify has returned.
+type queuedNotifier interface {
+	waitQueued()
+}
+
+// waitQueuedNotifications blocks until every channel has delivered what it
+// queued, e.g. before the process exits.
+func waitQueuedNotifications(notifiers []Notifier) {
+	for _, notifier := range notifiers {
+		if queued, ok := notifier.(queuedNotifier); ok {
+			queued.waitQueued()
+		}
+	}
+}
+
+// desktopPacing spaces out desktop notifications; macOS drops a notification
+// that arrives before the previous one has cleared.
+const desktopPacing = 2 * time.Second
+
+// desktopNotifier shows a notification right away when the previous one has
+// had time to clear. Otherwise it queues it for a background goroutine that
+// keeps the pacing, so Notify never sleeps and dispatchNotification is not
+// held up by it; failures of queued notifications are logged.
+type desktopNotifier struct {
+	name   string
+	pacing time.Duration
+	show   func(Notification) error
+
+	mu       sync.Mutex
+	lastSent time.Time
+	queue    []Notification
+	draining bool
+	drained  sync.WaitGroup
+}
+
+func newDesktopNotifier(_, name string, _ ChannelSettings) (Notifier, error) {
+	return &desktopNotifier{name: name, pacing: desktopPacing, show: showDesktopNotification}, nil
+}
+
+func (d *desktopNotifier) Name() string { return d.name }
+
+func (d *desktopNotifier) Notify(n Notification) error {
+	d.mu.Lock()
+	if d.draining || time.Since(d.lastSent) < d.pacing {
+		d.queue = append(d.queue, n)
+		if !d.draining {
+			d.draining = true
+			d.drained.Add(1)
+			go d.drain()
+		}
+		d.mu.Unlock()
+		return nil
+	}
+	d.lastSent = time.Now()
+	d.mu.Unlock()
+	return d.show(n)
+}
+
+// drain shows the queued notifications one pacing interval apart and exits
+// once the queue is empty.
+func (d *desktopNotifier) drain() {
+	defer d.drained.Done()
+	for {
+		d.mu.Lock()
+		if len(d.queue) == 0 {
+			d.draining = false
+			d.mu.Unlock()
+			return
+		}
+		n := d.queue[0]
+		d.queue = d.queue[1:]
+		wait := d.pacing - time.Since(d.lastSent)
+		d.mu.Unlock()
+
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		d.mu.Lock()
+		d.lastSent = time.Now()
+		d.mu.Unlock()
+		if err := d.show(n); err != nil {
+			log.Printf("Notification via %s failed: %v", d.name, err)
+		}
+	}
+}
+
+func (d *desktopNotifier) waitQueued() { d.drained.Wait() }
+
+func showDesktopNotification(n Notification) error {
+	iconPath := "assets/warning.png"
+	if _, err := os.Stat(iconPath); err != nil {
+		iconPath = ""
+	}
+	if err := beeep.Alert(n.Title, n.Message, iconPath); err != nil {
+		return fmt.Errorf("notification failed: %w", err)
+	}
+	return nil
+}
+
+type logNotifier struct {
+	name string
+	path string
+	mu   sync.Mutex
+}
+
+func newLogNotifier(dir, name string, channel ChannelSettings) (Notifier, error) {
+	if channel.Log == nil || strings.TrimSpace(channel.Log.Path) == "" {
+		return nil, fmt.Errorf("log channel needs log.path")
+	}
+
+	path := channel.Log.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return &logNotifier{name: name, path: path}, nil
+}
+
+func (l *logNotifier) Name() string { return l.name }
+
+func (l *logNotifier) Notify(n Notification) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Digests and summaries span several lines; keep one entry per line.
+	message := strings.ReplaceAll(n.Message, "\n", " | ")
+	line := fmt.Sprintf("%s [%s] %s\n", n.Time.Format(time.RFC3339), n.Kind, message)
+	if _, err := file.WriteString(line); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	name string
+	err  error
+
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordingNotifier) Name() string { return r.name }
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return r.err
+}
+
+func TestBuildNotifiersDefaultsToDesktop(t *testing.T) {
+	notifiers, err := buildNotifiers(t.TempDir(), nil, nil)
+	if err != nil {
+		t.Fatalf("buildNotifiers failed: %v", err)
+	}
+	if len(notifiers) != 1 || notifiers[0].Name() != channelDesktop {
+		t.Fatalf("expected a single desktop channel, got: %v", notifiers)
+	}
+}
+
+func TestBuildNotifiersRejectsInvalidChannels(t *testing.T) {
+	if _, err := buildNotifiers(t.TempDir(), []ChannelSettings{{Type: "pager"}}, nil); err == nil {
+		t.Fatalf("expected unknown channel type error")
+	}
+	if _, err := buildNotifiers(t.TempDir(), []ChannelSettings{{Type: channelLog}}, nil); err == nil {
+		t.Fatalf("expected error for log channel without a path")
+	}
+}
+
+func TestBuildNotifiersNamesDuplicateChannels(t *testing.T) {
+	channels := []ChannelSettings{
+		{Type: channelLog, Log: &LogChannelConfig{Path: "a.log"}},
+		{Type: channelLog, Log: &LogChannelConfig{Path: "b.log"}},
+	}
+	notifiers, err := buildNotifiers(t.TempDir(), channels, nil)
+	if err != nil {
+		t.Fatalf("buildNotifiers failed: %v", err)
+	}
+	if notifiers[0].Name() != "log" || notifiers[1].Name() != "log-2" {
+		t.Fatalf("unexpected channel names: %s, %s", notifiers[0].Name(), notifiers[1].Name())
+	}
+}
+
+func TestDispatchNotificationContinuesAfterChannelFailure(t *testing.T) {
+	failing := &recordingNotifier{name: "failing", err: fmt.Errorf("unreachable")}
+	healthy := &recordingNotifier{name: "healthy"}
+
+	dispatchNotification([]Notifier{failing, healthy}, Notification{Kind: notificationAlert, Message: "hello"})
+
+	if len(failing.sent) != 1 || len(healthy.sent) != 1 {
+		t.Fatalf("every channel should receive the notification: failing=%d healthy=%d", len(failing.sent), len(healthy.sent))
+	}
+}
+
+func TestDispatchDoesNotWaitForDesktopPacing(t *testing.T) {
+	shown := &recordingNotifier{name: channelDesktop}
+	desktop := &desktopNotifier{name: channelDesktop, pacing: 200 * time.Millisecond, show: shown.Notify}
+	recording := &recordingNotifier{name: "recording"}
+	notifiers := []Notifier{desktop, recording}
+
+	start := time.Now()
+	dispatchNotification(notifiers, Notification{Kind: notificationAlert, Symbol: "AAPL"})
+	dispatchNotification(notifiers, Notification{Kind: notificationAlert, Symbol: "MSFT"})
+	if elapsed := time.Since(start); elapsed >= desktop.pacing {
+		t.Fatalf("dispatch waited %v for the desktop pacing", elapsed)
+	}
+	if len(recording.sent) != 2 {
+		t.Fatalf("recording channel should have both alerts, got %d", len(recording.sent))
+	}
+
+	waitQueuedNotifications(notifiers)
+	if elapsed := time.Since(start); elapsed < desktop.pacing {
+		t.Fatalf("queued desktop notification was shown after %v, want at least %v", elapsed, desktop.pacing)
+	}
+	if len(shown.sent) != 2 || shown.sent[1].Symbol != "MSFT" {
+		t.Fatalf("desktop should show both alerts in order, got: %+v", shown.sent)
+	}
+}
+
+func TestTestNotificationIsFormattedLikeAMarkedAlert(t *testing.T) {
+	n := sampleAlertNotification()
+	n.Kind = notificationTest
+	n = markTestNotification(n)
+	if marked := markTestNotification(n); marked.Message != n.Message {
+		t.Fatalf("marking twice should not repeat the prefix: %q", marked.Message)
+	}
+	if headline := chatHeadline(n); headline != "AAPL alert" {
+		t.Fatalf("unexpected chat headline: %q", headline)
+	}
+	if subject := emailSubject(n); subject != notificationTitle+": AAPL 178.20" {
+		t.Fatalf("unexpected email subject: %q", subject)
+	}
+
+	shown := &recordingNotifier{name: channelDesktop}
+	desktop := &desktopNotifier{name: channelDesktop, pacing: desktopPacing, show: shown.Notify}
+	if err := desktop.Notify(n); err != nil || !strings.HasPrefix(shown.sent[0].Message, testNotificationPrefix) {
+		t.Fatalf("desktop should show the marked message: %+v (%v)", shown.sent, err)
+	}
+
+	t.Setenv(defaultSlackTokenEnv, "xoxb-test")
+	t.Setenv(defaultDiscordWebhookEnv, "123/abc")
+	t.Setenv(defaultTelegramTokenEnv, "42:secret")
+	t.Setenv(defaultGotifyTokenEnv, "app-token")
+	server, captured := startChatServer(t, `{"ok": true}`)
+	channels := map[string]func() (Notifier, error){
+		"slack": func() (Notifier, error) {
+			return newSlackNotifier("", channelSlack, ChannelSettings{Slack: &SlackConfig{Channel: "#alerts", BaseURL: server.URL}})
+		},
+		"discord": func() (Notifier, error) {
+			return newDiscordNotifier("", channelDiscord, ChannelSettings{Discord: &DiscordConfig{BaseURL: server.URL}})
+		},
+		"telegram": func() (Notifier, error) {
+			return newTelegramNotifier("", channelTelegram, ChannelSettings{Telegram: &TelegramConfig{ChatID: "-100", BaseURL: server.URL}})
+		},
+		"ntfy": func() (Notifier, error) {
+			return newPushNotifier("", channelPush, ChannelSettings{Push: &PushConfig{Protocol: "ntfy", ServerURL: server.URL, Topic: "stocks"}})
+		},
+		"gotify": func() (Notifier, error) {
+			return newPushNotifier("", channelPush, ChannelSettings{Push: &PushConfig{Protocol: "gotify", ServerURL: server.URL}})
+		},
+		"webhook": func() (Notifier, error) {
+			return newWebhookNotifier(t.TempDir(), channelWebhook, ChannelSettings{Webhook: &WebhookConfig{URL: server.URL}})
+		},
+	}
+	for name, build := range channels {
+		notifier, err := build()
+		if err != nil {
+			t.Fatalf("%s: building the channel failed: %v", name, err)
+		}
+		captured.body = nil
+		if err := notifier.Notify(n); err != nil {
+			t.Fatalf("%s: Notify failed: %v", name, err)
+		}
+		body, _ := json.Marshal(captured.body)
+		if !strings.Contains(string(body), "Test notification") || !strings.Contains(string(body), "AAPL") {
+			t.Fatalf("%s: expected the marked alert, got %s", name, body)
+		}
+	}
+
+	dir := t.TempDir()
+	templated, err := buildNotifiers(dir, []ChannelSettings{{Type: channelLog, Log: &LogChannelConfig{Path: "alerts.log"}}}, &MessageTemplates{AlertBody: "{{.Symbol}} at {{.Quote.Price}}"})
+	if err != nil {
+		t.Fatalf("buildNotifiers failed: %v", err)
+	}
+	if err := templated[0].Notify(n); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "alerts.log"))
+	if !strings.Contains(string(data), "[test] Test notification: AAPL at 178.2") {
+		t.Fatalf("templated log line should keep the marker: %q", data)
+	}
+}
+
+func TestLogNotifierAppendsLines(t *testing.T) {
+	dir := t.TempDir()
+	notifiers, err := buildNotifiers(dir, []ChannelSettings{{Type: channelLog, Log: &LogChannelConfig{Path: "alerts.log"}}}, nil)
+	if err != nil {
+		t.Fatalf("buildNotifiers failed: %v", err)
+	}
+
+	now := time.Date(2024, 1, 5, 15, 30, 0, 0, time.UTC)
+	quote := Quote{Symbol: "AAPL", Price: 179.5, Source: providerStockpricesDev}
+	dispatchNotification(notifiers, newAlertNotification("AAPL", AlertRule{Threshold: 180, Direction: directionBelow}, quote, now))
+	dispatchNotification(notifiers, newErrorNotification("TSLA", fmt.Errorf("provider down"), now))
+
+	raw, err := os.ReadFile(filepath.Join(dir, "alerts.log"))
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two log lines, got: %q", raw)
+	}
+	if lines[0] != "2024-01-05T15:30:00Z [alert] Price of stock AAPL: 179.50 [stockprices.dev] (target below 180.00)" {
+		t.Fatalf("unexpected alert line: %q", lines[0])
+	}
+	if lines[1] != "2024-01-05T15:30:00Z [error] Error: provider down" {
+		t.Fatalf("unexpected error line: %q", lines[1])
+	}
+}
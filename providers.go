@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	providerStockpricesDev = "stockprices.dev"
+	providerStooq          = "stooq"
+)
+
+// QuoteProvider is a source of stock prices. Providers are tried in the order
+// configured by the provider chain until one of them returns a price.
+type QuoteProvider interface {
+	// Name is the identifier used in the settings file provider chain.
+	Name() string
+	// Supports reports whether the provider can quote the given symbol.
+	Supports(symbol string) bool
+	// Delayed reports whether quotes are delayed; delayed providers are only
+	// used when delayed fallback is enabled.
+	Delayed() bool
+	// Fetch returns the current quote; it must give up when ctx is done.
+	Fetch(ctx context.Context, symbol string) (Quote, error)
+}
+
+// Quote is a price snapshot for a symbol. Optional fields are nil when the
+// provider does not report them.
+type Quote struct {
+	Symbol        string    `json:"symbol"`
+	Name          string    `json:"name,omitempty"`
+	Price         float64   `json:"price"`
+	Change        *float64  `json:"change,omitempty"`
+	ChangePercent *float64  `json:"changePercent,omitempty"`
+	Open          *float64  `json:"open,omitempty"`
+	High          *float64  `json:"high,omitempty"`
+	Low           *float64  `json:"low,omitempty"`
+	Volume        *int64    `json:"volume,omitempty"`
+	Source        string    `json:"source"`
+	AsOf          time.Time `json:"asOf"`
+	Delayed       bool      `json:"delayed"`
+	// Session is the trading session the price is from (pre, regular, post
+	// or closed), or empty when the exchange calendar is unknown.
+	Session string `json:"session,omitempty"`
+}
+
+// formatQuoteSummary renders the price with whatever extra detail the
+// provider returned, e.g. "178.20 (-2.10, -1.16%) [stockprices.dev]".
+func formatQuoteSummary(quote Quote) string {
+	summary := fmt.Sprintf("%.2f", quote.Price)
+	switch {
+	case quote.Change != nil && quote.ChangePercent != nil:
+		summary += fmt.Sprintf(" (%+.2f, %+.2f%%)", *quote.Change, *quote.ChangePercent)
+	case quote.ChangePercent != nil:
+		summary += fmt.Sprintf(" (%+.2f%%)", *quote.ChangePercent)
+	}
+
+	source := quote.Source
+	if quote.Delayed {
+		source += ", delayed"
+		if !quote.AsOf.IsZero() {
+			source += " as of " + quote.AsOf.Format("2006-01-02 15:04")
+		}
+	}
+	if label := sessionLabels[quote.Session]; label != "" {
+		if source != "" {
+			source += ", "
+		}
+		source += label
+	}
+	if source != "" {
+		summary += " [" + source + "]"
+	}
+	return summary
+}
+
+var sessionLabels = map[string]string{
+	sessionPre:     "pre-market",
+	sessionRegular: "regular session",
+	sessionPost:    "after-hours",
+	sessionClosed:  "market closed",
+}
+
+// displaySymbol returns the symbol followed by the company name when known.
+func displaySymbol(quote Quote) string {
+	if quote.Name == "" || strings.EqualFold(quote.Name, quote.Symbol) {
+		return quote.Symbol
+	}
+	return fmt.Sprintf("%s (%s)", quote.Symbol, quote.Name)
+}
+
+var defaultProviderChain = []string{providerStockpricesDev, providerStooq}
+
+var quoteProviders = map[string]QuoteProvider{}
+
+func registerQuoteProvider(provider QuoteProvider) {
+	quoteProviders[provider.Name()] = provider
+}
+
+func init() {
+	registerQuoteProvider(stockpricesDevProvider{})
+	registerQuoteProvider(stooqProvider{})
+}
+
+func resolveProviderChain(names []string) ([]QuoteProvider, error) {
+	if len(names) == 0 {
+		names = defaultProviderChain
+	}
+
+	chain := make([]QuoteProvider, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		provider, ok := quoteProviders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown quote provider %q (available: %s)", name, strings.Join(registeredProviderNames(), ", "))
+		}
+		seen[name] = true
+		chain = append(chain, provider)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("provider chain is empty")
+	}
+	return chain, nil
+}
+
+func registeredProviderNames() []string {
+	names := make([]string, 0, len(quoteProviders))
+	for _, name := range defaultProviderChain {
+		if _, ok := quoteProviders[name]; ok {
+			names = append(names, name)
+		}
+	}
+	for name := range quoteProviders {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	providerFailureThreshold = 3
+	providerCooldown         = 5 * time.Minute
+)
+
+// circuitBreaker disables a provider for a cooldown period after repeated
+// failures. It is shared by the fetch workers.
+type circuitBreaker struct {
+	mu            sync.Mutex
+	failureCount  int
+	disabledUntil time.Time
+}
+
+var (
+	providerBreakersMu sync.Mutex
+	providerBreakers   = map[string]*circuitBreaker{}
+)
+
+func breakerFor(name string) *circuitBreaker {
+	providerBreakersMu.Lock()
+	defer providerBreakersMu.Unlock()
+	breaker, ok := providerBreakers[name]
+	if !ok {
+		breaker = &circuitBreaker{}
+		providerBreakers[name] = breaker
+	}
+	return breaker
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.disabledUntil.IsZero() {
+		return true
+	}
+	return now.After(b.disabledUntil)
+}
+
+func (b *circuitBreaker) markSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failureCount = 0
+	b.disabledUntil = time.Time{}
+}
+
+func (b *circuitBreaker) markFailure(name string, now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failureCount++
+	if b.failureCount >= providerFailureThreshold {
+		b.disabledUntil = now.Add(providerCooldown)
+		log.Printf("Provider %s disabled for %s after %d failures: last error: %v", name, providerCooldown, b.failureCount, err)
+	}
+}
+
+// ProviderLimit caps how hard the fetch workers may hit one quote provider.
+type ProviderLimit struct {
+	// MaxConcurrent is the number of requests allowed in flight at once;
+	// zero uses defaultProviderConcurrency.
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// RequestsPerMinute spaces requests evenly; zero disables rate limiting.
+	RequestsPerMinute float64 `json:"requestsPerMinute,omitempty"`
+}
+
+const defaultProviderConcurrency = 4
+
+// providerLimiter enforces a ProviderLimit across the fetch workers.
+type providerLimiter struct {
+	limit    ProviderLimit
+	slots    chan struct{}
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+var (
+	providerLimitersMu sync.Mutex
+	providerLimiters   = map[string]*providerLimiter{}
+)
+
+// limiterFor returns the limiter for the named provider, replacing it when
+// the configured limit has changed.
+func limiterFor(name string, limit ProviderLimit) *providerLimiter {
+	if limit.MaxConcurrent <= 0 {
+		limit.MaxConcurrent = defaultProviderConcurrency
+	}
+
+	providerLimitersMu.Lock()
+	defer providerLimitersMu.Unlock()
+	limiter, ok := providerLimiters[name]
+	if !ok || limiter.limit != limit {
+		limiter = &providerLimiter{limit: limit, slots: make(chan struct{}, limit.MaxConcurrent)}
+		if limit.RequestsPerMinute > 0 {
+			limiter.interval = time.Duration(float64(time.Minute) / limit.RequestsPerMinute)
+		}
+		providerLimiters[name] = limiter
+	}
+	return limiter
+}
+
+// acquire waits for a free slot and for the request's turn under the rate
+// limit. Callers must call release once acquire succeeds.
+func (l *providerLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.interval <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	turn := l.next
+	if turn.Before(now) {
+		turn = now
+	}
+	l.next = turn.Add(l.interval)
+	l.mu.Unlock()
+
+	wait := turn.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.release()
+		return ctx.Err()
+	}
+}
+
+func (l *providerLimiter) release() {
+	<-l.slots
+}
+
+func validateProviderLimits(limits map[string]ProviderLimit) error {
+	for name, limit := range limits {
+		if _, ok := quoteProviders[name]; !ok {
+			return fmt.Errorf("provider limits: unknown quote provider %q (available: %s)", name, strings.Join(registeredProviderNames(), ", "))
+		}
+		if limit.MaxConcurrent < 0 || limit.RequestsPerMinute < 0 {
+			return fmt.Errorf("provider limits for %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+type stockpricesDevProvider struct{}
+
+func (stockpricesDevProvider) Name() string { return providerStockpricesDev }
+
+// Supports only plain US tickers; suffixed symbols such as INFY.NS are not listed.
+func (stockpricesDevProvider) Supports(symbol string) bool {
+	return !strings.Contains(symbol, ".")
+}
+
+func (stockpricesDevProvider) Delayed() bool { return false }
+
+func (stockpricesDevProvider) Fetch(ctx context.Context, symbol string) (Quote, error) {
+	return getStockpricesDevQuote(ctx, symbol)
+}
+
+type stooqProvider struct{}
+
+func (stooqProvider) Name() string { return providerStooq }
+
+func (stooqProvider) Supports(symbol string) bool {
+	return normalizeStooqSymbol(symbol) != ""
+}
+
+func (stooqProvider) Delayed() bool { return true }
+
+func (stooqProvider) Fetch(ctx context.Context, symbol string) (Quote, error) {
+	return getStooqQuote(ctx, symbol)
+}
+
+type stockpricesDevResponse struct {
+	Ticker           string   `json:"Ticker"`
+	Name             string   `json:"Name"`
+	Price            *float64 `json:"Price"`
+	ChangeAmount     *float64 `json:"ChangeAmount"`
+	ChangePercentage *float64 `json:"ChangePercentage"`
+}
+
+func getStockpricesDevQuote(ctx context.Context, symbol string) (Quote, error) {
+	cleanSymbol := normalizeStockpricesSymbol(symbol)
+	if cleanSymbol == "" {
+		return Quote{}, fmt.Errorf("symbol cannot be empty")
+	}
+
+	quote, err := fetchStockpricesDev(ctx, cleanSymbol, "stocks")
+	if err == nil {
+		return quote, nil
+	}
+
+	// If it's not a stock symbol, try the ETF endpoint.
+	etfQuote, etfErr := fetchStockpricesDev(ctx, cleanSymbol, "etfs")
+	if etfErr == nil {
+		return etfQuote, nil
+	}
+
+	lookupErr := fmt.Errorf("stockprices.dev lookup failed for %q: stocks error: %v; etfs error: %v", cleanSymbol, err, etfErr)
+	if errors.Is(err, errUnknownSymbol) && errors.Is(etfErr, errUnknownSymbol) {
+		return Quote{}, classify(errUnknownSymbol, lookupErr)
+	}
+	return Quote{}, lookupErr
+}
+
+func fetchStockpricesDev(ctx context.Context, symbol, instrument string) (Quote, error) {
+	url := fmt.Sprintf("https://stockprices.dev/api/%s/%s", instrument, symbol)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("User-Agent", "stocks-notifier/1.0")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to fetch quote for symbol %q: %v", symbol, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			msg = resp.Status
+		}
+		statusErr := fmt.Errorf("unexpected status %d for %q: %s", resp.StatusCode, symbol, msg)
+		if resp.StatusCode == http.StatusNotFound {
+			return Quote{}, classify(errUnknownSymbol, statusErr)
+		}
+		return Quote{}, statusErr
+	}
+
+	var payload stockpricesDevResponse
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return Quote{}, fmt.Errorf("failed to decode quote response for %q: %v", symbol, err)
+	}
+
+	if payload.Price == nil {
+		return Quote{}, fmt.Errorf("missing price for symbol %q", symbol)
+	}
+
+	return Quote{
+		Symbol:        symbol,
+		Name:          strings.TrimSpace(payload.Name),
+		Price:         *payload.Price,
+		Change:        payload.ChangeAmount,
+		ChangePercent: payload.ChangePercentage,
+		AsOf:          time.Now(),
+	}, nil
+}
+
+func normalizeStockpricesSymbol(symbol string) string {
+	symbol = strings.TrimSpace(symbol)
+	if symbol == "" {
+		return ""
+	}
+	if dot := strings.Index(symbol, "."); dot != -1 {
+		symbol = symbol[:dot]
+	}
+	return strings.ToUpper(symbol)
+}
+
+func getStooqQuote(ctx context.Context, symbol string) (Quote, error) {
+	stooqSymbol := normalizeStooqSymbol(symbol)
+	if stooqSymbol == "" {
+		return Quote{}, fmt.Errorf("symbol cannot be empty")
+	}
+	url := fmt.Sprintf("https://stooq.com/q/l/?s=%s&i=d", stooqSymbol)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("User-Agent", "stocks-notifier/1.0")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to fetch quote for symbol %q: %v", symbol, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("unexpected status %d fetching quote for %q", resp.StatusCode, symbol)
+	}
+
+	return parseStooqCSV(symbol, resp.Body)
+}
+
+// Column positions used when Stooq returns a row without a header:
+// Symbol,Date,Time,Open,High,Low,Close,Volume.
+var stooqDefaultColumns = map[string]int{
+	"date":   1,
+	"time":   2,
+	"open":   3,
+	"high":   4,
+	"low":    5,
+	"close":  6,
+	"volume": 7,
+}
+
+func parseStooqCSV(symbol string, body io.Reader) (Quote, error) {
+	reader := csv.NewReader(body)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to read CSV for %q: %v", symbol, err)
+	}
+	if len(records) == 0 {
+		return Quote{}, fmt.Errorf("empty quote response for %q", symbol)
+	}
+
+	header := records[0]
+	row := header
+	columns := map[string]int{}
+
+	if len(records) > 1 && len(header) > 0 && strings.EqualFold(strings.TrimSpace(header[0]), "Symbol") {
+		row = records[1]
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+	} else if len(row) >= 7 {
+		// Stooq sometimes returns data without a header.
+		columns = stooqDefaultColumns
+	}
+
+	field := func(name string) string {
+		idx, ok := columns[name]
+		if !ok || idx >= len(row) {
+			return ""
+		}
+		value := strings.TrimSpace(row[idx])
+		if strings.EqualFold(value, "N/D") {
+			return ""
+		}
+		return value
+	}
+
+	if _, ok := columns["close"]; !ok || columns["close"] >= len(row) {
+		return Quote{}, fmt.Errorf("close price not found for symbol %q", symbol)
+	}
+
+	closeVal := field("close")
+	if closeVal == "" {
+		// Stooq answers unknown symbols with N/D in every field.
+		return Quote{}, classify(errUnknownSymbol, fmt.Errorf("close price unavailable for symbol %q", symbol))
+	}
+
+	price, err := strconv.ParseFloat(closeVal, 64)
+	if err != nil {
+		return Quote{}, fmt.Errorf("invalid close price %q for symbol %q", closeVal, symbol)
+	}
+
+	quote := Quote{
+		Symbol:  symbol,
+		Price:   price,
+		Open:    parseOptionalFloat(field("open")),
+		High:    parseOptionalFloat(field("high")),
+		Low:     parseOptionalFloat(field("low")),
+		AsOf:    parseStooqTimestamp(field("date"), field("time")),
+		Delayed: true,
+	}
+	if volume := parseOptionalFloat(field("volume")); volume != nil {
+		v := int64(*volume)
+		quote.Volume = &v
+	}
+
+	return quote, nil
+}
+
+func parseOptionalFloat(raw string) *float64 {
+	if raw == "" {
+		return nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// stooqLocation is the timezone of Stooq's date and time columns, which are
+// Warsaw local time for every exchange.
+var stooqLocation = mustLoadLocation("Europe/Warsaw")
+
+// parseStooqTimestamp combines Stooq's date and time columns. A date without
+// a time says nothing about the trading session, so it is left zero and the
+// quote's session stays unknown.
+func parseStooqTimestamp(date, clock string) time.Time {
+	if date == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		day, err := time.Parse(layout, date)
+		if err != nil {
+			continue
+		}
+		for _, clockLayout := range []string{"15:04:05", "150405"} {
+			if t, err := time.Parse(clockLayout, clock); err == nil {
+				return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, stooqLocation)
+			}
+		}
+		return time.Time{}
+	}
+	return time.Time{}
+}
+
+func normalizeStooqSymbol(symbol string) string {
+	symbol = strings.TrimSpace(symbol)
+	if symbol == "" {
+		return ""
+	}
+	if strings.Contains(symbol, ".") {
+		return strings.ToLower(symbol)
+	}
+	return strings.ToLower(symbol) + ".us"
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeQuoteProvider struct {
+	name    string
+	delayed bool
+	price   float64
+	err     error
+	// asOf stamps returned quotes so tests can place them in a session.
+	asOf          time.Time
+	changePercent *float64
+	// delay holds each fetch so tests can observe concurrency.
+	delay time.Duration
+
+	mu          sync.Mutex
+	calls       int
+	inFlight    int
+	maxInFlight int
+}
+
+func (p *fakeQuoteProvider) Name() string         { return p.name }
+func (p *fakeQuoteProvider) Supports(string) bool { return true }
+func (p *fakeQuoteProvider) Delayed() bool        { return p.delayed }
+func (p *fakeQuoteProvider) Fetch(ctx context.Context, symbol string) (Quote, error) {
+	p.mu.Lock()
+	p.calls++
+	p.inFlight++
+	if p.inFlight > p.maxInFlight {
+		p.maxInFlight = p.inFlight
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	if p.delay > 0 {
+		select {
+		case <-time.After(p.delay):
+		case <-ctx.Done():
+			return Quote{}, ctx.Err()
+		}
+	}
+	if p.err != nil {
+		return Quote{}, p.err
+	}
+	return Quote{Symbol: symbol, Price: p.price, AsOf: p.asOf, ChangePercent: p.changePercent}, nil
+}
+
+func useFakeProviders(t *testing.T, providers ...*fakeQuoteProvider) {
+	t.Helper()
+	savedProviders, savedBreakers, savedLimiters, savedSettings := quoteProviders, providerBreakers, providerLimiters, appSettings
+	t.Cleanup(func() {
+		quoteProviders, providerBreakers, providerLimiters, appSettings = savedProviders, savedBreakers, savedLimiters, savedSettings
+	})
+
+	quoteProviders = map[string]QuoteProvider{}
+	providerBreakers = map[string]*circuitBreaker{}
+	providerLimiters = map[string]*providerLimiter{}
+	appSettings = AppSettings{}
+	for _, provider := range providers {
+		registerQuoteProvider(provider)
+		appSettings.Providers = append(appSettings.Providers, provider.name)
+	}
+}
+
+func TestResolveProviderChain(t *testing.T) {
+	chain, err := resolveProviderChain(nil)
+	if err != nil {
+		t.Fatalf("default chain should resolve: %v", err)
+	}
+	if len(chain) != 2 || chain[0].Name() != providerStockpricesDev || chain[1].Name() != providerStooq {
+		t.Fatalf("unexpected default chain: %v", chain)
+	}
+
+	chain, err = resolveProviderChain([]string{" Stooq ", "stockprices.dev", "stooq"})
+	if err != nil {
+		t.Fatalf("custom chain should resolve: %v", err)
+	}
+	if len(chain) != 2 || chain[0].Name() != providerStooq {
+		t.Fatalf("custom chain order not respected: %v", chain)
+	}
+
+	if _, err := resolveProviderChain([]string{"yahoo"}); err == nil {
+		t.Fatalf("expected unknown provider error")
+	}
+}
+
+func TestGetStockPriceFallsBackThroughChain(t *testing.T) {
+	t.Setenv("STOCKS_NOTIFIER_ALLOW_DELAYED", "1")
+	primary := &fakeQuoteProvider{name: "primary", err: fmt.Errorf("down")}
+	secondary := &fakeQuoteProvider{name: "secondary", delayed: true, price: 42}
+	useFakeProviders(t, primary, secondary)
+
+	quote, err := GetStockPrice(context.Background(), "AAPL")
+	if err != nil {
+		t.Fatalf("expected fallback price, got error: %v", err)
+	}
+	if quote.Price != 42 || primary.calls != 1 || secondary.calls != 1 {
+		t.Fatalf("unexpected fallback result: price=%v primary=%d secondary=%d", quote.Price, primary.calls, secondary.calls)
+	}
+	if quote.Source != "secondary" || !quote.Delayed {
+		t.Fatalf("quote should record the provider it came from: %#v", quote)
+	}
+}
+
+func TestGetStockPriceSkipsDelayedProvidersWhenDisabled(t *testing.T) {
+	t.Setenv("STOCKS_NOTIFIER_ALLOW_DELAYED", "0")
+	delayed := &fakeQuoteProvider{name: "delayed", delayed: true, price: 42}
+	useFakeProviders(t, delayed)
+
+	if _, err := GetStockPrice(context.Background(), "AAPL"); err == nil {
+		t.Fatalf("expected error when only a delayed provider is configured")
+	}
+	if delayed.calls != 0 {
+		t.Fatalf("delayed provider should not be called when fallback is disabled")
+	}
+}
+
+func TestCircuitBreakerIsPerProvider(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	failing := breakerFor("failing")
+	healthy := breakerFor("healthy")
+	t.Cleanup(func() {
+		delete(providerBreakers, "failing")
+		delete(providerBreakers, "healthy")
+	})
+
+	for i := 0; i < providerFailureThreshold; i++ {
+		failing.markFailure("failing", now, fmt.Errorf("boom"))
+	}
+
+	if failing.allow(now.Add(time.Minute)) {
+		t.Fatalf("breaker should be open after %d failures", providerFailureThreshold)
+	}
+	if !failing.allow(now.Add(providerCooldown + time.Second)) {
+		t.Fatalf("breaker should close after cooldown")
+	}
+	if !healthy.allow(now) {
+		t.Fatalf("failures of one provider should not disable another")
+	}
+}
+
+func TestParseStooqCSVWithHeader(t *testing.T) {
+	body := strings.NewReader("Symbol,Date,Time,Open,High,Low,Close,Volume\nINFY.NS,2024-01-05,15:30:00,1500.5,1525,1490.25,1510.75,123456\n")
+
+	quote, err := parseStooqCSV("INFY.NS", body)
+	if err != nil {
+		t.Fatalf("parseStooqCSV failed: %v", err)
+	}
+	if quote.Price != 1510.75 || !quote.Delayed {
+		t.Fatalf("unexpected quote: %#v", quote)
+	}
+	if quote.Open == nil || *quote.Open != 1500.5 || quote.High == nil || *quote.High != 1525 || quote.Low == nil || *quote.Low != 1490.25 {
+		t.Fatalf("open/high/low not parsed: %#v", quote)
+	}
+	if quote.Volume == nil || *quote.Volume != 123456 {
+		t.Fatalf("volume not parsed: %#v", quote)
+	}
+	if want := time.Date(2024, 1, 5, 15, 30, 0, 0, stooqLocation); !quote.AsOf.Equal(want) {
+		t.Fatalf("expected as-of %v, got %v", want, quote.AsOf)
+	}
+}
+
+func TestStooqTimestampsAreWarsawTime(t *testing.T) {
+	calendar, err := newMarketCalendar(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("newMarketCalendar failed: %v", err)
+	}
+
+	cases := []struct {
+		row  string
+		want string
+	}{
+		// 21:30 in Warsaw is 15:30 in New York in winter and in summer.
+		{"AAPL.US,2024-01-05,21:30:00,1,1,1,180,1", sessionRegular},
+		{"AAPL.US,2024-07-05,21:30:00,1,1,1,180,1", sessionRegular},
+		// Half an hour after the close.
+		{"AAPL.US,2024-01-05,22:30:00,1,1,1,180,1", sessionPost},
+		{"AAPL.US,2024-07-05,22:30:00,1,1,1,180,1", sessionPost},
+		// A daily quote has no time, so its session is unknown.
+		{"AAPL.US,2024-01-05,N/D,1,1,1,180,1", ""},
+	}
+	for _, tc := range cases {
+		quote, err := parseStooqCSV("AAPL", strings.NewReader(tc.row+"\n"))
+		if err != nil {
+			t.Fatalf("parseStooqCSV(%q) failed: %v", tc.row, err)
+		}
+		if got := calendar.sessionAt("AAPL", quote.AsOf); got != tc.want {
+			t.Fatalf("session for %q = %q, want %q", tc.row, got, tc.want)
+		}
+	}
+}
+
+func TestParseStooqCSVWithoutHeaderAndMissingData(t *testing.T) {
+	quote, err := parseStooqCSV("TSLA", strings.NewReader("TSLA.US,20240105,220000,N/D,N/D,N/D,237.49,N/D\n"))
+	if err != nil {
+		t.Fatalf("parseStooqCSV failed: %v", err)
+	}
+	if quote.Price != 237.49 || quote.Open != nil || quote.Volume != nil {
+		t.Fatalf("unexpected quote: %#v", quote)
+	}
+
+	if _, err := parseStooqCSV("XXX", strings.NewReader("XXX.US,N/D,N/D,N/D,N/D,N/D,N/D,N/D\n")); err == nil {
+		t.Fatalf("expected error for unavailable close price")
+	}
+}
+
+func TestFormatQuoteSummary(t *testing.T) {
+	change, changePct := -2.1, -1.16
+	realtime := Quote{Symbol: "AAPL", Name: "Apple Inc", Price: 178.2, Change: &change, ChangePercent: &changePct, Source: providerStockpricesDev}
+	if got := formatQuoteSummary(realtime); got != "178.20 (-2.10, -1.16%) [stockprices.dev]" {
+		t.Fatalf("unexpected real-time summary: %q", got)
+	}
+	if got := displaySymbol(realtime); got != "AAPL (Apple Inc)" {
+		t.Fatalf("unexpected display symbol: %q", got)
+	}
+
+	delayed := Quote{Symbol: "INFY.NS", Price: 1510.75, Source: providerStooq, Delayed: true, AsOf: time.Date(2024, 1, 5, 15, 30, 0, 0, time.UTC)}
+	if got := formatQuoteSummary(delayed); got != "1510.75 [stooq, delayed as of 2024-01-05 15:30]" {
+		t.Fatalf("unexpected delayed summary: %q", got)
+	}
+}
+
+func TestProviderLimiterSpacesRequests(t *testing.T) {
+	useFakeProviders(t)
+	limiter := limiterFor("fake", ProviderLimit{RequestsPerMinute: 1200})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire failed: %v", err)
+		}
+		limiter.release()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected requests 50ms apart, three took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.acquire(ctx); err == nil {
+		limiter.release()
+		t.Fatalf("expected a cancelled context to stop waiting")
+	}
+	if len(limiter.slots) != 0 {
+		t.Fatalf("expected no slot to be held after cancellation")
+	}
+}
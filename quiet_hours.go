@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const notificationSummary = "summary"
+
+// QuietHoursSettings holds alerts back during the configured windows and
+// delivers them as one summary when the window ends. Urgent rules and rules
+// with BypassQuietHours set are always sent immediately.
+type QuietHoursSettings struct {
+	// Timezone is an IANA name such as "Europe/London"; empty uses the local
+	// timezone.
+	Timezone string        `json:"timezone,omitempty"`
+	Windows  []QuietWindow `json:"windows"`
+}
+
+// QuietWindow is a daily "HH:MM" range. A window whose end is before its
+// start runs past midnight into the next day.
+type QuietWindow struct {
+	// Days lists the weekdays the window starts on ("mon".."sun"); empty
+	// means every day.
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// suppressedAlert records an alert that was held back during quiet hours.
+type suppressedAlert struct {
+	Symbol  string `json:"symbol"`
+	Message string `json:"message"`
+	Unix    int64  `json:"unix"`
+}
+
+type quietSchedule struct {
+	location *time.Location
+	windows  []quietWindow
+}
+
+type quietWindow struct {
+	days [7]bool
+	// start and end are minutes after midnight.
+	start int
+	end   int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// newQuietSchedule validates the settings. It returns nil when no quiet
+// hours are configured.
+func newQuietSchedule(settings *QuietHoursSettings) (*quietSchedule, error) {
+	if settings == nil || len(settings.Windows) == 0 {
+		return nil, nil
+	}
+
+	schedule := &quietSchedule{location: time.Local}
+	if tz := strings.TrimSpace(settings.Timezone); tz != "" {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours timezone %q: %v", settings.Timezone, err)
+		}
+		schedule.location = location
+	}
+
+	for i, window := range settings.Windows {
+		start, err := parseClock(window.Start)
+		if err != nil {
+			return nil, fmt.Errorf("quiet hours window %d: invalid start: %v", i+1, err)
+		}
+		end, err := parseClock(window.End)
+		if err != nil {
+			return nil, fmt.Errorf("quiet hours window %d: invalid end: %v", i+1, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("quiet hours window %d: start and end must differ", i+1)
+		}
+
+		parsed := quietWindow{start: start, end: end}
+		if len(window.Days) == 0 {
+			for day := range parsed.days {
+				parsed.days[day] = true
+			}
+		}
+		for _, name := range window.Days {
+			day, ok := weekdayNames[strings.ToLower(strings.TrimSpace(name))]
+			if !ok {
+				return nil, fmt.Errorf("quiet hours window %d: unknown day %q", i+1, name)
+			}
+			parsed.days[day] = true
+		}
+		schedule.windows = append(schedule.windows, parsed)
+	}
+	return schedule, nil
+}
+
+func parseClock(value string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// activeUntil reports whether now falls in a quiet window and, if so, when the
+// latest matching window ends. A nil schedule is never quiet.
+func (q *quietSchedule) activeUntil(now time.Time) (time.Time, bool) {
+	if q == nil {
+		return time.Time{}, false
+	}
+
+	local := now.In(q.location)
+	var until time.Time
+	for _, window := range q.windows {
+		// A window that runs past midnight may have started yesterday.
+		for _, offset := range []int{0, -1} {
+			day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, q.location)
+			if !window.days[day.Weekday()] {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), window.start/60, window.start%60, 0, 0, q.location)
+			end := time.Date(day.Year(), day.Month(), day.Day(), window.end/60, window.end%60, 0, 0, q.location)
+			if window.end < window.start {
+				end = end.AddDate(0, 0, 1)
+			}
+			if !local.Before(start) && local.Before(end) && end.After(until) {
+				until = end
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// holdAlert records n in the rule's state so it is included in the summary
+// sent when quiet hours end. A later alert for the same rule replaces it.
+func holdAlert(key string, n Notification, state map[string]symbolAlertState) {
+	current := state[key]
+	current.Suppressed = &suppressedAlert{Symbol: n.Symbol, Message: n.Message, Unix: n.Time.Unix()}
+	state[key] = current
+}
+
+func hasHeldAlerts(state map[string]symbolAlertState) bool {
+	for _, current := range state {
+		if current.Suppressed != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseHeldAlerts clears and returns every held alert, oldest first.
+func releaseHeldAlerts(state map[string]symbolAlertState) []suppressedAlert {
+	var held []suppressedAlert
+	for key, current := range state {
+		if current.Suppressed == nil {
+			continue
+		}
+		held = append(held, *current.Suppressed)
+		current.Suppressed = nil
+		state[key] = current
+	}
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].Unix != held[j].Unix {
+			return held[i].Unix < held[j].Unix
+		}
+		return held[i].Message < held[j].Message
+	})
+	return held
+}
+
+// newQuietSummaryNotification lists alerts held during quiet hours with the
+// time each was raised.
+func newQuietSummaryNotification(held []suppressedAlert, location *time.Location, now time.Time) Notification {
+	if location == nil {
+		location = time.Local
+	}
+	noun := "alerts"
+	if len(held) == 1 {
+		noun = "alert"
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "%d %s held during quiet hours:", len(held), noun)
+	for _, alert := range held {
+		fmt.Fprintf(&message, "\n%s %s", time.Unix(alert.Unix, 0).In(location).Format("Mon 15:04"), alert.Message)
+	}
+
+	return Notification{
+		Kind:    notificationSummary,
+		Title:   notificationTitle,
+		Message: message.String(),
+		Time:    now,
+	}
+}
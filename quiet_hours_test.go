@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuietScheduleWindows(t *testing.T) {
+	schedule, err := newQuietSchedule(&QuietHoursSettings{
+		Timezone: "America/New_York",
+		Windows: []QuietWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "22:00", End: "07:00"},
+			{Days: []string{"Saturday", "sun"}, Start: "00:00", End: "10:00"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newQuietSchedule failed: %v", err)
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	cases := []struct {
+		name      string
+		now       time.Time
+		wantQuiet bool
+		wantUntil time.Time
+	}{
+		{"weekday late evening", time.Date(2024, 3, 12, 23, 30, 0, 0, newYork), true, time.Date(2024, 3, 13, 7, 0, 0, 0, newYork)},
+		{"weekday early morning", time.Date(2024, 3, 13, 3, 0, 0, 0, newYork), true, time.Date(2024, 3, 13, 7, 0, 0, 0, newYork)},
+		{"weekday daytime", time.Date(2024, 3, 13, 12, 0, 0, 0, newYork), false, time.Time{}},
+		{"friday night runs into saturday window", time.Date(2024, 3, 16, 3, 0, 0, 0, newYork), true, time.Date(2024, 3, 16, 10, 0, 0, 0, newYork)},
+		{"sunday night is not quiet", time.Date(2024, 3, 17, 23, 0, 0, 0, newYork), false, time.Time{}},
+		{"converted from UTC", time.Date(2024, 3, 13, 7, 0, 0, 0, time.UTC), true, time.Date(2024, 3, 13, 7, 0, 0, 0, newYork)},
+	}
+	for _, tc := range cases {
+		until, quiet := schedule.activeUntil(tc.now)
+		if quiet != tc.wantQuiet || !until.Equal(tc.wantUntil) {
+			t.Fatalf("%s: activeUntil = (%v, %v), want (%v, %v)", tc.name, until, quiet, tc.wantUntil, tc.wantQuiet)
+		}
+	}
+
+	var none *quietSchedule
+	if _, quiet := none.activeUntil(time.Now()); quiet {
+		t.Fatalf("expected no quiet hours without a schedule")
+	}
+}
+
+func TestQuietScheduleValidation(t *testing.T) {
+	cases := []struct {
+		settings QuietHoursSettings
+		want     string
+	}{
+		{QuietHoursSettings{Timezone: "Mars/Olympus", Windows: []QuietWindow{{Start: "22:00", End: "07:00"}}}, "timezone"},
+		{QuietHoursSettings{Windows: []QuietWindow{{Start: "10pm", End: "07:00"}}}, "invalid start"},
+		{QuietHoursSettings{Windows: []QuietWindow{{Start: "22:00", End: "22:00"}}}, "must differ"},
+		{QuietHoursSettings{Windows: []QuietWindow{{Days: []string{"someday"}, Start: "22:00", End: "07:00"}}}, "unknown day"},
+	}
+	for _, tc := range cases {
+		if _, err := newQuietSchedule(&tc.settings); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected error containing %q, got: %v", tc.want, err)
+		}
+	}
+}
+
+func TestHeldAlertsReleasedAsSummary(t *testing.T) {
+	state := map[string]symbolAlertState{}
+	night := time.Date(2024, 3, 13, 3, 0, 0, 0, time.UTC)
+
+	nvda := newAlertNotification("NVDA", AlertRule{Threshold: 800, Direction: directionBelow}, Quote{Symbol: "NVDA", Price: 790, Source: providerStockpricesDev}, night.Add(10*time.Minute))
+	aapl := newAlertNotification("AAPL", AlertRule{Threshold: 180, Direction: directionBelow}, Quote{Symbol: "AAPL", Price: 179, Source: providerStockpricesDev}, night)
+	if !shouldNotifyAlert("NVDA", true, false, 0, nvda.Time, state) || !shouldNotifyAlert("AAPL", true, false, 0, aapl.Time, state) {
+		t.Fatalf("expected both rules to trigger")
+	}
+	holdAlert("NVDA", nvda, state)
+	holdAlert("AAPL", aapl, state)
+
+	if !state["AAPL"].InAlert || state["AAPL"].Suppressed == nil || !hasHeldAlerts(state) {
+		t.Fatalf("expected held alert to be recorded in state: %+v", state["AAPL"])
+	}
+
+	held := releaseHeldAlerts(state)
+	if len(held) != 2 || held[0].Symbol != "AAPL" || held[1].Symbol != "NVDA" {
+		t.Fatalf("expected held alerts oldest first, got: %+v", held)
+	}
+	if hasHeldAlerts(state) || !state["AAPL"].InAlert {
+		t.Fatalf("expected release to clear held alerts but keep the latch: %+v", state)
+	}
+
+	summary := newQuietSummaryNotification(held, time.UTC, night.Add(4*time.Hour))
+	if summary.Kind != notificationSummary || !strings.HasPrefix(summary.Message, "2 alerts held during quiet hours:\nWed 03:00 Price of stock AAPL") {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestHeldAlertOutlivesRemovedRule(t *testing.T) {
+	state := map[string]symbolAlertState{}
+	night := time.Date(2024, 3, 13, 3, 0, 0, 0, time.UTC)
+	alert := newAlertNotification("AAPL", AlertRule{Threshold: 180, Direction: directionBelow}, Quote{Symbol: "AAPL", Price: 179, Source: providerStockpricesDev}, night)
+	shouldNotifyAlert("AAPL", true, false, 0, night, state)
+	holdAlert("AAPL", alert, state)
+
+	// The rule is removed during quiet hours.
+	remaining := map[string][]AlertRule{"MSFT": {{Threshold: 400, Direction: directionBelow}}}
+	pruneAlertState(state, remaining)
+	if current := state["AAPL"]; current.Suppressed == nil || current.InAlert {
+		t.Fatalf("expected only the held alert to be kept: %+v", state)
+	}
+
+	held := releaseHeldAlerts(state)
+	if len(held) != 1 || held[0].Symbol != "AAPL" {
+		t.Fatalf("expected the held alert in the summary, got: %+v", held)
+	}
+	pruneAlertState(state, remaining)
+	if _, ok := state["AAPL"]; ok {
+		t.Fatalf("expected the released entry to be pruned: %+v", state)
+	}
+}
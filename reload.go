@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// configCheckInterval is how often the monitor looks for edits to
+// stocks.json and the settings file while it sleeps.
+const configCheckInterval = 2 * time.Second
+
+// fileStamp identifies a version of a file by its modification time and size.
+// A missing file has the zero stamp.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// configChanged reports whether stocks.json or the settings file changed since
+// they were last read. A changed settings file is reloaded here; stocks.json
+// is re-read by the next poll.
+func (m *Monitor) configChanged() bool {
+	changed := false
+	if statFile(filepath.Join(m.dir, settingsFile)) != m.settingsStamp {
+		log.Printf("Settings file changed, reloading")
+		m.applyReload()
+		changed = true
+	}
+	if statFile(filepath.Join(m.dir, "stocks.json")) != m.rulesStamp {
+		log.Printf("stocks.json changed, polling now")
+		changed = true
+	}
+	return changed
+}
+
+// loadRules re-reads stocks.json when it has changed. A version that fails to
+// parse is reported once and the last good rules stay in use.
+func (m *Monitor) loadRules(notifiers []Notifier) {
+	stamp := statFile(filepath.Join(m.dir, "stocks.json"))
+	if m.rulesChecked && stamp == m.rulesStamp {
+		return
+	}
+	m.rulesChecked, m.rulesStamp = true, stamp
+
+	rules, err := readJSONData(m.dir)
+	if err != nil {
+		if m.rules != nil {
+			err = fmt.Errorf("%v; keeping the previous rules", err)
+		}
+		err = classify(errConfig, err)
+		m.rulesError = err.Error()
+		if notification, ok := m.tracker.report("", err, time.Now()); ok {
+			dispatchNotification(notifiers, notification)
+		}
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	m.rules, m.rulesError = rules, ""
+	if notification, ok := m.tracker.resolve("", time.Now()); ok {
+		dispatchNotification(notifiers, notification)
+	}
+}
+
+// applyReload swaps in freshly read settings after validating them. Settings
+// that fail to parse or validate are reported once and the previous ones
+// stay in use. Alerts waiting for a digest are carried over.
+func (m *Monitor) applyReload() {
+	m.settingsStamp = statFile(filepath.Join(m.dir, settingsFile))
+	settings, err := readAppSettings(m.dir)
+	if err == nil {
+		var cfg monitorConfig
+		if cfg, err = newMonitorConfig(m.dir, settings); err == nil {
+			m.swapConfig(cfg)
+			return
+		}
+	}
+
+	err = classify(errConfig, fmt.Errorf("settings not reloaded, keeping the previous ones: %v", err))
+	m.settingsError = err.Error()
+	log.Printf("Error: %v", err)
+	notification := newErrorNotification("", err, time.Now())
+	notification.ErrorClass = errorClassConfig
+	dispatchNotification(m.cfg.notifiers, notification)
+}
+
+func (m *Monitor) swapConfig(cfg monitorConfig) {
+	if cfg.digest != nil && m.cfg.digest != nil {
+		cfg.digest.pending = m.cfg.digest.pending
+	} else if notification, ok := m.cfg.digest.drain(time.Now()); ok {
+		dispatchNotification(cfg.notifiers, notification)
+	}
+	m.cfg = cfg
+	m.tracker.backoff = cfg.errorBackoff
+	m.settingsError = ""
+	log.Printf("Reloaded settings")
+}
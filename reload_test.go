@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorKeepsLastGoodRulesAndReportsOnce(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, recorder := newTestMonitor(t, `{"AAA": 150}`, AppSettings{})
+	stocksPath := filepath.Join(monitor.dir, "stocks.json")
+
+	monitor.poll(context.Background())
+	if len(recorder.sent) != 1 || recorder.sent[0].Symbol != "AAA" {
+		t.Fatalf("expected the AAA alert, got: %+v", recorder.sent)
+	}
+
+	if err := os.WriteFile(stocksPath, []byte(`{"AAA": 150,`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	if !monitor.configChanged() {
+		t.Fatalf("expected the edit to be detected")
+	}
+	monitor.poll(context.Background())
+	monitor.poll(context.Background())
+
+	if len(recorder.sent) != 2 || recorder.sent[1].Kind != notificationError || !strings.Contains(recorder.sent[1].Message, "keeping the previous rules") {
+		t.Fatalf("expected one error notification for the broken file, got: %+v", recorder.sent)
+	}
+	if !monitor.alertState["AAA"].InAlert || monitor.snapshot().rules["AAA"] == nil || monitor.snapshot().ConfigError == "" {
+		t.Fatalf("expected the previous rules and their state to stay in use: %+v", monitor.snapshot())
+	}
+
+	if err := os.WriteFile(stocksPath, []byte(`{"AAA": 150, "BBB": 50}`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	monitor.poll(context.Background())
+	if len(recorder.sent) != 3 || recorder.sent[2].Kind != notificationRecovered {
+		t.Fatalf("expected a recovery notification, got: %+v", recorder.sent)
+	}
+	if monitor.snapshot().rules["BBB"] == nil || monitor.snapshot().ConfigError != "" {
+		t.Fatalf("expected the fixed rules to be used: %+v", monitor.snapshot())
+	}
+}
+
+func TestMonitorReloadsChangedSettings(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, recorder := newTestMonitor(t, `{"AAA": 50}`, AppSettings{})
+	monitor.poll(context.Background())
+	if monitor.configChanged() {
+		t.Fatalf("expected no change right after startup")
+	}
+
+	settings := currentSettings()
+	settings.PollInterval = "4m"
+	if err := writeAppSettings(monitor.dir, settings); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	if !monitor.configChanged() || monitor.cfg.basePollInterval != 4*time.Minute {
+		t.Fatalf("expected the new poll interval to apply, got %s", monitor.cfg.basePollInterval)
+	}
+
+	if err := os.WriteFile(filepath.Join(monitor.dir, settingsFile), []byte(`{"pollInterval": `), 0o644); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	if !monitor.configChanged() || monitor.cfg.basePollInterval != 4*time.Minute {
+		t.Fatalf("expected the last good settings to stay in use, got %s", monitor.cfg.basePollInterval)
+	}
+	if monitor.configChanged() {
+		t.Fatalf("expected the broken file to be read only once")
+	}
+	if len(recorder.sent) != 1 || recorder.sent[0].ErrorClass != errorClassConfig {
+		t.Fatalf("expected one config error notification, got: %+v", recorder.sent)
+	}
+}
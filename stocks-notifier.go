@@ -1,167 +1,322 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/gen2brain/beeep"
 )
 
-func getDirectoryPath() (string, error) {
-	// Check if a directory path was provided
-	if len(os.Args) < 2 {
-		return "", fmt.Errorf("file path not provided")
-	}
-
-	// Get the directory path from the command-line argument
-	dir := os.Args[1]
-
-	// Check if the directory path is a valid directory
-	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
-		return "", fmt.Errorf("'%s' is not a valid directory", dir)
-	}
-
-	// Return the directory path
-	return dir, nil
-}
-
-func directoryPathHelpMessage() {
-	fmt.Println("\nProvide the path where the stocks.json file is stored as an argument.\n\nThis file should contain the stock prices at which an alert is to be generated, as shown in the example below:")
-	fmt.Println("\nExample stocks.json file:")
-	fmt.Println(strings.TrimSpace(`
-{
-  "TSLA": 220,
-  "AAPL": {
-    "threshold": 250,
-    "direction": "above"
-  }
-}`))
-	fmt.Println("\nOptional flags:")
-	fmt.Println("  --web           Start local configuration UI")
-	fmt.Println("  --addr=HOST:PORT  Change UI bind address (default 127.0.0.1:8080)")
-	fmt.Println("\nCheckout documentation if you need any help:")
-	fmt.Println("- https://blog.vmhatre.com/stocks-notifier/")
-	fmt.Println("- https://github.com/Vedant-Mhatre/stocks-notifier")
-	os.Exit(1)
-}
-
 const (
 	directionBelow              = "below"
 	directionAbove              = "above"
+	directionChangePctAbove     = "change_pct_above"
+	directionChangePctBelow     = "change_pct_below"
+	directionBetween            = "between"
+	directionOutside            = "outside"
+	directionTrailingStop       = "trailing_stop"
+	severityLow                 = "low"
+	severityDefault             = "default"
+	severityHigh                = "high"
+	severityUrgent              = "urgent"
 	alertStateFile              = ".stocks-notifier-state.json"
 	settingsFile                = ".stocks-notifier-settings.json"
 	defaultPollInterval         = 10 * time.Minute
 	defaultNearInterval         = 2 * time.Minute
 	defaultNearThresholdPercent = 2.0
+	defaultFetchWorkers         = 8
 )
 
 type symbolAlertState struct {
-	InAlert          bool  `json:"in_alert"`
-	LastNotifiedUnix int64 `json:"last_notified_unix,omitempty"`
+	InAlert          bool    `json:"in_alert"`
+	LastNotifiedUnix int64   `json:"last_notified_unix,omitempty"`
+	PeakPrice        float64 `json:"peak_price,omitempty"`
+	// RuleID is the derived id of the lone unnamed rule that state under a
+	// bare symbol key belongs to, so the state can follow that rule when the
+	// symbol gains others.
+	RuleID string `json:"rule_id,omitempty"`
+	// Suppressed is an alert held back during quiet hours, delivered in the
+	// summary when the window ends.
+	Suppressed *suppressedAlert `json:"suppressed,omitempty"`
 }
 
 type AppSettings struct {
-	AllowDelayedFallback bool    `json:"allowDelayedFallback"`
-	ReminderInterval     string  `json:"reminderInterval,omitempty"`
-	PollInterval         string  `json:"pollInterval,omitempty"`
-	PollNearInterval     string  `json:"pollNearInterval,omitempty"`
-	NearThresholdPercent float64 `json:"nearThresholdPercent,omitempty"`
+	AllowDelayedFallback bool                `json:"allowDelayedFallback"`
+	ReminderInterval     string              `json:"reminderInterval,omitempty"`
+	PollInterval         string              `json:"pollInterval,omitempty"`
+	PollNearInterval     string              `json:"pollNearInterval,omitempty"`
+	NearThresholdPercent float64             `json:"nearThresholdPercent,omitempty"`
+	Providers            []string            `json:"providers,omitempty"`
+	RearmPercent         float64             `json:"rearmPercent,omitempty"`
+	Channels             []ChannelSettings   `json:"channels,omitempty"`
+	Templates            *MessageTemplates   `json:"templates,omitempty"`
+	QuietHours           *QuietHoursSettings `json:"quietHours,omitempty"`
+	Digest               *DigestSettings     `json:"digest,omitempty"`
+	// ErrorBackoff is how long a repeated error stays quiet before it is
+	// notified again.
+	ErrorBackoff string               `json:"errorBackoff,omitempty"`
+	MarketHours  *MarketHoursSettings `json:"marketHours,omitempty"`
+	// FetchWorkers is how many symbols are looked up at once.
+	FetchWorkers int `json:"fetchWorkers,omitempty"`
+	// ProviderLimits caps concurrency and request rate per quote provider.
+	ProviderLimits map[string]ProviderLimit `json:"providerLimits,omitempty"`
 }
 
-type cliOptions struct {
-	Dir  string
-	Web  bool
-	Addr string
+var (
+	// appSettingsMu guards appSettings, which the fetch workers read while a
+	// reload or the web UI may replace it.
+	appSettingsMu sync.RWMutex
+	appSettings   AppSettings
+)
+
+func currentSettings() AppSettings {
+	appSettingsMu.RLock()
+	defer appSettingsMu.RUnlock()
+	return appSettings
 }
 
-var appSettings AppSettings
+func setAppSettings(settings AppSettings) {
+	appSettingsMu.Lock()
+	defer appSettingsMu.Unlock()
+	appSettings = settings
+}
 
 type AlertRule struct {
+	ID        string  `json:"id,omitempty"`
 	Threshold float64 `json:"threshold"`
 	Direction string  `json:"direction,omitempty"`
+	Lower     float64 `json:"lower,omitempty"`
+	Upper     float64 `json:"upper,omitempty"`
+	// RearmPercent is how far the price must move back from the trigger
+	// before the rule can alert again, in percent of the trigger price; for
+	// change rules it is percentage points of the day's change, like the
+	// threshold. Zero uses the global default.
+	RearmPercent float64 `json:"rearmPercent,omitempty"`
+	// TrailPercent is the drop from the highest price seen since the rule was
+	// created that triggers a trailing_stop rule.
+	TrailPercent float64 `json:"trailPercent,omitempty"`
+	// Severity ranks the alert for channels that support priorities: low,
+	// default, high or urgent. Empty means default.
+	Severity string `json:"severity,omitempty"`
+	// Session limits the rule to regular trading hours ("regular"), the
+	// regular plus pre-market and after-hours sessions ("extended"), or
+	// any time ("always", the default).
+	Session string `json:"session,omitempty"`
+	// BypassQuietHours sends the rule's alerts immediately during quiet hours
+	// instead of holding them for the summary. Urgent rules always do.
+	BypassQuietHours bool `json:"bypassQuietHours,omitempty"`
+
+	// derivedID is set when assignRuleIDs derived ID rather than reading it
+	// from the config, so it is not written back.
+	derivedID bool
+}
+
+// bypassesQuietHours reports whether the rule's alerts go out during quiet
+// hours.
+func (r AlertRule) bypassesQuietHours() bool {
+	return r.BypassQuietHours || r.Severity == severityUrgent
 }
 
 func (rule *AlertRule) normalize() error {
+	rule.Severity = strings.ToLower(strings.TrimSpace(rule.Severity))
+	switch rule.Severity {
+	case "", severityLow, severityDefault, severityHigh, severityUrgent:
+	default:
+		return fmt.Errorf("unsupported severity %q (supported: %q, %q, %q, %q)", rule.Severity, severityLow, severityDefault, severityHigh, severityUrgent)
+	}
+
+	rule.Session = strings.ToLower(strings.TrimSpace(rule.Session))
+	switch rule.Session {
+	case "", sessionRegular, sessionExtended, sessionAlways:
+	default:
+		return fmt.Errorf("unsupported session %q (supported: %q, %q, %q)", rule.Session, sessionRegular, sessionExtended, sessionAlways)
+	}
+
 	rule.Direction = strings.ToLower(strings.TrimSpace(rule.Direction))
 	if rule.Direction == "" {
 		rule.Direction = directionBelow
 	}
-	if rule.Direction != directionBelow && rule.Direction != directionAbove {
-		return fmt.Errorf("unsupported direction %q (supported: %q, %q)", rule.Direction, directionBelow, directionAbove)
+	switch rule.Direction {
+	case directionBelow, directionAbove, directionChangePctAbove, directionChangePctBelow:
+		return nil
+	case directionBetween, directionOutside:
+		if rule.Lower >= rule.Upper {
+			return fmt.Errorf("%s rule needs lower (%.2f) to be less than upper (%.2f)", rule.Direction, rule.Lower, rule.Upper)
+		}
+		return nil
+	case directionTrailingStop:
+		if rule.TrailPercent <= 0 || rule.TrailPercent >= 100 {
+			return fmt.Errorf("trailing_stop rule needs trailPercent between 0 and 100, got %.2f", rule.TrailPercent)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported direction %q (supported: %q, %q, %q, %q, %q, %q, %q)", rule.Direction, directionBelow, directionAbove, directionChangePctAbove, directionChangePctBelow, directionBetween, directionOutside, directionTrailingStop)
+	}
+}
+
+// isBandDirection reports whether the rule uses lower/upper bounds instead of
+// a single threshold.
+func isBandDirection(direction string) bool {
+	return direction == directionBetween || direction == directionOutside
+}
+
+// isChangeDirection reports whether the rule compares the day's change
+// percentage rather than the absolute price.
+func isChangeDirection(direction string) bool {
+	return direction == directionChangePctAbove || direction == directionChangePctBelow
+}
+
+func describeRule(rule AlertRule) string {
+	switch rule.Direction {
+	case directionChangePctAbove:
+		return fmt.Sprintf("day change >= %+.2f%%", rule.Threshold)
+	case directionChangePctBelow:
+		return fmt.Sprintf("day change <= %+.2f%%", rule.Threshold)
+	case directionBetween:
+		return fmt.Sprintf("between %.2f and %.2f", rule.Lower, rule.Upper)
+	case directionOutside:
+		return fmt.Sprintf("outside %.2f-%.2f", rule.Lower, rule.Upper)
+	case directionTrailingStop:
+		if rule.Threshold > 0 {
+			return fmt.Sprintf("trailing stop %.2f%% (stop %.2f)", rule.TrailPercent, rule.Threshold)
+		}
+		return fmt.Sprintf("trailing stop %.2f%%", rule.TrailPercent)
+	default:
+		return fmt.Sprintf("%s %.2f", rule.Direction, rule.Threshold)
 	}
-	return nil
 }
 
-func parseStockRules(rawRules map[string]json.RawMessage) (map[string]AlertRule, error) {
-	rules := make(map[string]AlertRule, len(rawRules))
+// parseStockRules accepts, per symbol, a number (legacy below rule), a rule
+// object, or an array of rule objects.
+func parseStockRules(rawRules map[string]json.RawMessage) (map[string][]AlertRule, error) {
+	rules := make(map[string][]AlertRule, len(rawRules))
 
 	for symbol, rawRule := range rawRules {
-		var legacyThreshold float64
-		if err := json.Unmarshal(rawRule, &legacyThreshold); err == nil {
-			rules[symbol] = AlertRule{
-				Threshold: legacyThreshold,
-				Direction: directionBelow,
-			}
-			continue
+		var rawList []json.RawMessage
+		if err := json.Unmarshal(rawRule, &rawList); err != nil {
+			rawList = []json.RawMessage{rawRule}
 		}
 
-		var rule AlertRule
-		if err := json.Unmarshal(rawRule, &rule); err != nil {
-			return nil, fmt.Errorf("invalid rule for %q: expected a number or object: %v", symbol, err)
+		symbolRules := make([]AlertRule, 0, len(rawList))
+		for _, rawEntry := range rawList {
+			rule, err := parseAlertRule(rawEntry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule for %q: %v", symbol, err)
+			}
+			symbolRules = append(symbolRules, rule)
 		}
 
-		if err := rule.normalize(); err != nil {
-			return nil, fmt.Errorf("invalid rule for %q: %v", symbol, err)
+		if err := assignRuleIDs(symbolRules); err != nil {
+			return nil, fmt.Errorf("invalid rules for %q: %v", symbol, err)
 		}
 
-		rules[symbol] = rule
+		rules[symbol] = symbolRules
 	}
 
 	return rules, nil
 }
 
-func parseCLIOptions() (cliOptions, error) {
-	if len(os.Args) < 2 {
-		return cliOptions{}, fmt.Errorf("file path not provided")
+func parseAlertRule(rawRule json.RawMessage) (AlertRule, error) {
+	var legacyThreshold float64
+	if err := json.Unmarshal(rawRule, &legacyThreshold); err == nil {
+		return AlertRule{
+			Threshold: legacyThreshold,
+			Direction: directionBelow,
+		}, nil
 	}
 
-	dir := os.Args[1]
-	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
-		return cliOptions{}, fmt.Errorf("'%s' is not a valid directory", dir)
+	var rule AlertRule
+	if err := json.Unmarshal(rawRule, &rule); err != nil {
+		return AlertRule{}, fmt.Errorf("expected a number or object: %v", err)
 	}
 
-	opts := cliOptions{
-		Dir:  dir,
-		Addr: "127.0.0.1:8080",
+	if err := rule.normalize(); err != nil {
+		return AlertRule{}, err
 	}
 
-	for _, arg := range os.Args[2:] {
-		switch {
-		case arg == "--web":
-			opts.Web = true
-		case strings.HasPrefix(arg, "--addr="):
-			opts.Addr = strings.TrimSpace(strings.TrimPrefix(arg, "--addr="))
-			if opts.Addr == "" {
-				return cliOptions{}, fmt.Errorf("--addr cannot be empty")
-			}
-		default:
-			return cliOptions{}, fmt.Errorf("unsupported argument %q", arg)
+	return rule, nil
+}
+
+// assignRuleIDs gives every unnamed rule of a multi-rule symbol an id
+// derived from what it checks (see ruleContentID) and rejects duplicate ids.
+// A lone rule may keep an empty id so that its alert state stays keyed by the
+// bare symbol.
+func assignRuleIDs(rules []AlertRule) error {
+	seen := make(map[string]bool, len(rules))
+	// Explicit ids are claimed first so a derived id never takes one.
+	for i := range rules {
+		rules[i].ID = strings.TrimSpace(rules[i].ID)
+		if rules[i].ID == "" {
+			continue
+		}
+		if seen[rules[i].ID] {
+			return fmt.Errorf("duplicate rule id %q", rules[i].ID)
+		}
+		seen[rules[i].ID] = true
+	}
+	if len(rules) < 2 {
+		return nil
+	}
+
+	for i := range rules {
+		if rules[i].ID != "" {
+			continue
 		}
+		base := ruleContentID(rules[i])
+		id := base
+		for n := 2; seen[id]; n++ {
+			id = base + "-" + strconv.Itoa(n)
+		}
+		rules[i].ID = id
+		rules[i].derivedID = true
+		seen[id] = true
+	}
+	return nil
+}
+
+// ruleContentID derives the id of an unnamed rule from its direction and
+// values, e.g. "below-180" or "outside-380-420", so that adding, removing or
+// reordering other rules does not move its alert state.
+func ruleContentID(rule AlertRule) string {
+	value := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	switch {
+	case isBandDirection(rule.Direction):
+		return rule.Direction + "-" + value(rule.Lower) + "-" + value(rule.Upper)
+	case rule.Direction == directionTrailingStop:
+		return rule.Direction + "-" + value(rule.TrailPercent)
+	default:
+		return rule.Direction + "-" + value(rule.Threshold)
 	}
+}
+
+// withoutDerivedID clears the rule's id when assignRuleIDs derived it, so the
+// id is derived again from the rule's current values. Ids from the config are
+// kept even when they look derived.
+func withoutDerivedID(rule AlertRule) AlertRule {
+	if rule.derivedID {
+		rule.ID, rule.derivedID = "", false
+	}
+	return rule
+}
 
-	return opts, nil
+// ruleKey identifies a rule in the alert state file and in log messages.
+func ruleKey(symbol string, rule AlertRule) string {
+	if rule.ID == "" {
+		return symbol
+	}
+	return symbol + "#" + rule.ID
 }
 
-func readJSONData(dir string) (map[string]AlertRule, error) {
+func readJSONData(dir string) (map[string][]AlertRule, error) {
 
 	fullPath := filepath.Join(dir, "stocks.json") //This is required to get platform specific path
 
@@ -187,8 +342,23 @@ func readJSONData(dir string) (map[string]AlertRule, error) {
 	return parseStockRules(rawRules)
 }
 
-func writeJSONData(dir string, rules map[string]AlertRule) error {
+func writeJSONData(dir string, rules map[string][]AlertRule) error {
 	fullPath := filepath.Join(dir, "stocks.json")
+
+	// Derived ids are left out so they follow the rule's values, and symbols
+	// with a single unnamed rule keep the compact object form.
+	output := make(map[string]any, len(rules))
+	for symbol, symbolRules := range rules {
+		written := make([]AlertRule, len(symbolRules))
+		for i, rule := range symbolRules {
+			written[i] = withoutDerivedID(rule)
+		}
+		if len(written) == 1 && written[0].ID == "" {
+			output[symbol] = written[0]
+			continue
+		}
+		output[symbol] = written
+	}
 	tmpPath := fullPath + ".tmp"
 
 	tmpFile, err := os.Create(tmpPath)
@@ -198,7 +368,7 @@ func writeJSONData(dir string, rules map[string]AlertRule) error {
 
 	enc := json.NewEncoder(tmpFile)
 	enc.SetIndent("", "  ")
-	if err := enc.Encode(rules); err != nil {
+	if err := enc.Encode(output); err != nil {
 		_ = tmpFile.Close()
 		return err
 	}
@@ -255,20 +425,17 @@ func writeAppSettings(dir string, settings AppSettings) error {
 	return os.Rename(tmpPath, fullPath)
 }
 
-func notify(text string) error {
-	iconPath := "assets/warning.png"
-	if _, err := os.Stat(iconPath); err != nil {
-		iconPath = ""
-	}
-	if err := beeep.Alert("Stock notifier", text, iconPath); err != nil {
-		return fmt.Errorf("notification failed: %w", err)
+// GetStockPrice asks each provider in the chain for a quote until one
+// succeeds. It is safe to call from several goroutines.
+func GetStockPrice(ctx context.Context, symbol string) (Quote, error) {
+	if symbol == "" {
+		return Quote{}, fmt.Errorf("symbol cannot be empty")
 	}
-	return nil
-}
 
-func GetStockPrice(symbol string) (float64, error) {
-	if symbol == "" {
-		return 0, fmt.Errorf("symbol cannot be empty")
+	settings := currentSettings()
+	chain, err := resolveProviderChain(settings.Providers)
+	if err != nil {
+		return Quote{}, classify(errConfig, err)
 	}
 
 	allowDelayed := allowDelayedFallbackEnabled()
@@ -276,238 +443,90 @@ func GetStockPrice(symbol string) (float64, error) {
 		log.Printf("Warning: %q looks like a non-US ticker. Real-time quotes only support plain US tickers; set STOCKS_NOTIFIER_ALLOW_DELAYED=1 to use delayed quotes.", symbol)
 	}
 
-	if !strings.Contains(symbol, ".") {
-		if !allowRealtimeRequest() {
-			if allowDelayed {
-				delayedPrice, delayedErr := getStooqQuote(symbol)
-				if delayedErr == nil {
-					return delayedPrice, nil
-				}
-				return 0, fmt.Errorf("real-time provider temporarily disabled; delayed provider failed: %v", delayedErr)
-			}
-			return 0, fmt.Errorf("real-time provider temporarily disabled due to recent failures")
+	var failures []string
+	// The symbol is reported unknown only when every provider that was
+	// asked says so.
+	allUnknown := true
+	skippedDelayed := false
+	for _, provider := range chain {
+		if !provider.Supports(symbol) {
+			continue
 		}
-
-		price, err := getStockpricesDevQuote(symbol)
-		if err == nil {
-			markRealtimeSuccess()
-			return price, nil
+		if provider.Delayed() && !allowDelayed {
+			skippedDelayed = true
+			continue
 		}
-		markRealtimeFailure(err)
 
-		if allowDelayed {
-			delayedPrice, delayedErr := getStooqQuote(symbol)
-			if delayedErr == nil {
-				return delayedPrice, nil
-			}
-			return 0, fmt.Errorf("real-time provider failed: %v; delayed provider failed: %v", err, delayedErr)
+		breaker := breakerFor(provider.Name())
+		if !breaker.allow(time.Now()) {
+			failures = append(failures, fmt.Sprintf("%s temporarily disabled due to recent failures", provider.Name()))
+			allUnknown = false
+			continue
 		}
 
-		return 0, err
-	}
-
-	if allowDelayed {
-		delayedPrice, delayedErr := getStooqQuote(symbol)
-		if delayedErr == nil {
-			return delayedPrice, nil
+		limiter := limiterFor(provider.Name(), settings.ProviderLimits[provider.Name()])
+		if err := limiter.acquire(ctx); err != nil {
+			return Quote{}, err
 		}
-		return 0, fmt.Errorf("delayed provider failed: %v", delayedErr)
-	}
-
-	return 0, fmt.Errorf("real-time quotes only support plain US tickers (no suffix). For symbols like %q, set STOCKS_NOTIFIER_ALLOW_DELAYED=1 to use delayed quotes", symbol)
-}
-
-const (
-	realtimeFailureThreshold = 3
-	realtimeCooldown         = 5 * time.Minute
-)
-
-var (
-	realtimeFailureCount  int
-	realtimeDisabledUntil time.Time
-)
-
-func allowRealtimeRequest() bool {
-	if realtimeDisabledUntil.IsZero() {
-		return true
-	}
-	return time.Now().After(realtimeDisabledUntil)
-}
-
-func markRealtimeSuccess() {
-	realtimeFailureCount = 0
-	realtimeDisabledUntil = time.Time{}
-}
-
-func markRealtimeFailure(err error) {
-	realtimeFailureCount++
-	if realtimeFailureCount >= realtimeFailureThreshold {
-		realtimeDisabledUntil = time.Now().Add(realtimeCooldown)
-		log.Printf("Real-time provider disabled for %s after %d failures: last error: %v", realtimeCooldown, realtimeFailureCount, err)
-	}
-}
-
-type stockpricesDevResponse struct {
-	Ticker           string   `json:"Ticker"`
-	Name             string   `json:"Name"`
-	Price            *float64 `json:"Price"`
-	ChangeAmount     *float64 `json:"ChangeAmount"`
-	ChangePercentage *float64 `json:"ChangePercentage"`
-}
-
-func getStockpricesDevQuote(symbol string) (float64, error) {
-	cleanSymbol := normalizeStockpricesSymbol(symbol)
-	if cleanSymbol == "" {
-		return 0, fmt.Errorf("symbol cannot be empty")
-	}
-
-	price, err := fetchStockpricesDev(cleanSymbol, "stocks")
-	if err == nil {
-		return price, nil
-	}
-
-	// If it's not a stock symbol, try the ETF endpoint.
-	etfPrice, etfErr := fetchStockpricesDev(cleanSymbol, "etfs")
-	if etfErr == nil {
-		return etfPrice, nil
-	}
-
-	return 0, fmt.Errorf("stockprices.dev lookup failed for %q: stocks error: %v; etfs error: %v", cleanSymbol, err, etfErr)
-}
-
-func fetchStockpricesDev(symbol, instrument string) (float64, error) {
-	url := fmt.Sprintf("https://stockprices.dev/api/%s/%s", instrument, symbol)
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return 0, fmt.Errorf("failed to build request: %v", err)
-	}
-	req.Header.Set("User-Agent", "stocks-notifier/1.0")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("failed to fetch quote for symbol %q: %v", symbol, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		msg := strings.TrimSpace(string(body))
-		if msg == "" {
-			msg = resp.Status
+		quote, err := provider.Fetch(ctx, symbol)
+		limiter.release()
+		if err != nil && ctx.Err() != nil {
+			// Cancellation says nothing about the provider's health.
+			return Quote{}, ctx.Err()
 		}
-		return 0, fmt.Errorf("unexpected status %d for %q: %s", resp.StatusCode, symbol, msg)
-	}
-
-	var payload stockpricesDevResponse
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return 0, fmt.Errorf("failed to decode quote response for %q: %v", symbol, err)
-	}
-
-	if payload.Price == nil {
-		return 0, fmt.Errorf("missing price for symbol %q", symbol)
-	}
-
-	return *payload.Price, nil
-}
-
-func normalizeStockpricesSymbol(symbol string) string {
-	symbol = strings.TrimSpace(symbol)
-	if symbol == "" {
-		return ""
-	}
-	if dot := strings.Index(symbol, "."); dot != -1 {
-		symbol = symbol[:dot]
-	}
-	return strings.ToUpper(symbol)
-}
-
-func getStooqQuote(symbol string) (float64, error) {
-	stooqSymbol := normalizeStooqSymbol(symbol)
-	if stooqSymbol == "" {
-		return 0, fmt.Errorf("symbol cannot be empty")
-	}
-	url := fmt.Sprintf("https://stooq.com/q/l/?s=%s&i=d", stooqSymbol)
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return 0, fmt.Errorf("failed to build request: %v", err)
-	}
-	req.Header.Set("User-Agent", "stocks-notifier/1.0")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("failed to fetch quote for symbol %q: %v", symbol, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("unexpected status %d fetching quote for %q", resp.StatusCode, symbol)
-	}
-
-	reader := csv.NewReader(resp.Body)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return 0, fmt.Errorf("failed to read CSV for %q: %v", symbol, err)
-	}
-	if len(records) == 0 {
-		return 0, fmt.Errorf("empty quote response for %q", symbol)
-	}
-
-	header := records[0]
-	row := header
-	closeIdx := -1
-
-	if len(records) > 1 && len(header) > 0 && strings.EqualFold(strings.TrimSpace(header[0]), "Symbol") {
-		row = records[1]
-		for i, name := range header {
-			if strings.EqualFold(strings.TrimSpace(name), "Close") {
-				closeIdx = i
-				break
-			}
+		if err == nil {
+			breaker.markSuccess()
+			quote.Symbol = symbol
+			quote.Source = provider.Name()
+			quote.Delayed = provider.Delayed()
+			return quote, nil
 		}
-	} else if len(row) >= 7 {
-		// Stooq sometimes returns data without a header.
-		closeIdx = 6
-	}
-
-	if closeIdx == -1 || closeIdx >= len(row) {
-		return 0, fmt.Errorf("close price not found for symbol %q", symbol)
+		failures = append(failures, fmt.Sprintf("%s failed: %v", provider.Name(), err))
+		if errors.Is(err, errUnknownSymbol) {
+			// The provider answered; an unknown symbol says nothing about
+			// its health.
+			breaker.markSuccess()
+			continue
+		}
+		breaker.markFailure(provider.Name(), time.Now(), err)
+		allUnknown = false
 	}
 
-	closeVal := strings.TrimSpace(row[closeIdx])
-	if closeVal == "" || strings.EqualFold(closeVal, "N/D") {
-		return 0, fmt.Errorf("close price unavailable for symbol %q", symbol)
+	if len(failures) > 0 {
+		lookupErr := fmt.Errorf("quote lookup failed for %q: %s", symbol, strings.Join(failures, "; "))
+		if allUnknown {
+			return Quote{}, classify(errUnknownSymbol, lookupErr)
+		}
+		return Quote{}, classify(errProviderDown, lookupErr)
 	}
-
-	price, err := strconv.ParseFloat(closeVal, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid close price %q for symbol %q", closeVal, symbol)
+	if skippedDelayed {
+		return Quote{}, classify(errConfig, fmt.Errorf("real-time quotes only support plain US tickers (no suffix). For symbols like %q, set STOCKS_NOTIFIER_ALLOW_DELAYED=1 to use delayed quotes", symbol))
 	}
-
-	return price, nil
+	return Quote{}, classify(errConfig, fmt.Errorf("no configured quote provider supports symbol %q", symbol))
 }
 
-func normalizeStooqSymbol(symbol string) string {
-	symbol = strings.TrimSpace(symbol)
-	if symbol == "" {
-		return ""
-	}
-	if strings.Contains(symbol, ".") {
-		return strings.ToLower(symbol)
+func shouldSendAlert(quote Quote, rule AlertRule) bool {
+	if !ruleInSession(rule, quote.Session) {
+		return false
 	}
-	return strings.ToLower(symbol) + ".us"
-}
 
-func shouldSendAlert(price float64, rule AlertRule) bool {
-	if rule.Direction == directionAbove {
-		return price >= rule.Threshold
+	switch rule.Direction {
+	case directionAbove:
+		return quote.Price >= rule.Threshold
+	case directionChangePctAbove:
+		return quote.ChangePercent != nil && *quote.ChangePercent >= rule.Threshold
+	case directionChangePctBelow:
+		return quote.ChangePercent != nil && *quote.ChangePercent <= rule.Threshold
+	case directionBetween:
+		return quote.Price >= rule.Lower && quote.Price <= rule.Upper
+	case directionOutside:
+		return quote.Price < rule.Lower || quote.Price > rule.Upper
+	case directionTrailingStop:
+		// Threshold holds the stop level resolved by resolveTrailingStop.
+		return rule.Threshold > 0 && quote.Price <= rule.Threshold
+	default:
+		return quote.Price <= rule.Threshold
 	}
-	return price <= rule.Threshold
 }
 
 func readAlertState(dir string) (map[string]symbolAlertState, error) {
@@ -570,7 +589,7 @@ func writeAlertState(dir string, state map[string]symbolAlertState) error {
 }
 
 func getReminderIntervalFromEnv() time.Duration {
-	return getDurationWithSetting("STOCKS_NOTIFIER_REMINDER_INTERVAL", appSettings.ReminderInterval, 0)
+	return getDurationWithSetting("STOCKS_NOTIFIER_REMINDER_INTERVAL", currentSettings().ReminderInterval, 0)
 }
 
 func getDurationWithSetting(envKey, settingValue string, defaultValue time.Duration) time.Duration {
@@ -595,9 +614,10 @@ func getDurationWithSetting(envKey, settingValue string, defaultValue time.Durat
 }
 
 func allowDelayedFallbackEnabled() bool {
+	settings := currentSettings()
 	raw := strings.TrimSpace(os.Getenv("STOCKS_NOTIFIER_ALLOW_DELAYED"))
 	if raw == "" {
-		return appSettings.AllowDelayedFallback
+		return settings.AllowDelayedFallback
 	}
 
 	switch strings.ToLower(raw) {
@@ -607,14 +627,15 @@ func allowDelayedFallbackEnabled() bool {
 		return false
 	default:
 		log.Printf("Invalid STOCKS_NOTIFIER_ALLOW_DELAYED value %q, using settings/default", raw)
-		return appSettings.AllowDelayedFallback
+		return settings.AllowDelayedFallback
 	}
 }
 
 func getNearThresholdPercentFromEnv() float64 {
+	settings := currentSettings()
 	raw := strings.TrimSpace(os.Getenv("STOCKS_NOTIFIER_NEAR_THRESHOLD_PERCENT"))
-	if raw == "" && appSettings.NearThresholdPercent > 0 {
-		return appSettings.NearThresholdPercent
+	if raw == "" && settings.NearThresholdPercent > 0 {
+		return settings.NearThresholdPercent
 	}
 	if raw == "" {
 		return defaultNearThresholdPercent
@@ -629,7 +650,72 @@ func getNearThresholdPercentFromEnv() float64 {
 	return parsed
 }
 
-func percentDistanceToTrigger(price float64, rule AlertRule) float64 {
+func getRearmPercentFromEnv() float64 {
+	settings := currentSettings()
+	raw := strings.TrimSpace(os.Getenv("STOCKS_NOTIFIER_REARM_PERCENT"))
+	if raw == "" {
+		return settings.RearmPercent
+	}
+
+	parsed, err := strconv.ParseFloat(raw, 64)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid STOCKS_NOTIFIER_REARM_PERCENT value %q, using settings/default", raw)
+		return settings.RearmPercent
+	}
+
+	return parsed
+}
+
+func getFetchWorkersFromEnv() int {
+	settings := currentSettings()
+	raw := strings.TrimSpace(os.Getenv("STOCKS_NOTIFIER_FETCH_WORKERS"))
+	if raw == "" && settings.FetchWorkers > 0 {
+		return settings.FetchWorkers
+	}
+	if raw == "" {
+		return defaultFetchWorkers
+	}
+
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid STOCKS_NOTIFIER_FETCH_WORKERS value %q, using default %d", raw, defaultFetchWorkers)
+		return defaultFetchWorkers
+	}
+
+	return parsed
+}
+
+// effectiveRearmPercent is the rule's re-arm margin, compared with
+// percentDistanceToTrigger and so in the same units.
+func effectiveRearmPercent(rule AlertRule, defaultPercent float64) float64 {
+	if rule.RearmPercent > 0 {
+		return rule.RearmPercent
+	}
+	if defaultPercent > 0 {
+		return defaultPercent
+	}
+	return 0
+}
+
+// percentDistanceToTrigger returns how far the quote is from triggering the
+// rule. Change rules are measured in percentage points of the day's change and
+// band rules by the distance to the nearest bound.
+func percentDistanceToTrigger(quote Quote, rule AlertRule) float64 {
+	if isBandDirection(rule.Direction) {
+		return percentDistanceToBand(quote.Price, rule)
+	}
+
+	if isChangeDirection(rule.Direction) {
+		if quote.ChangePercent == nil {
+			return 100
+		}
+		if shouldSendAlert(quote, rule) {
+			return 0
+		}
+		return math.Abs(*quote.ChangePercent - rule.Threshold)
+	}
+
+	price := quote.Price
 	if rule.Threshold <= 0 {
 		return 100
 	}
@@ -647,7 +733,31 @@ func percentDistanceToTrigger(price float64, rule AlertRule) float64 {
 	return ((price - rule.Threshold) / rule.Threshold) * 100
 }
 
-func determineNextPollInterval(prices map[string]float64, rules map[string]AlertRule, baseInterval, nearInterval time.Duration, nearThresholdPercent float64) (time.Duration, string) {
+func percentDistanceToBand(price float64, rule AlertRule) float64 {
+	if rule.Lower <= 0 || rule.Upper <= 0 {
+		return 100
+	}
+	if shouldSendAlert(Quote{Price: price}, rule) {
+		return 0
+	}
+
+	if rule.Direction == directionBetween {
+		if price < rule.Lower {
+			return ((rule.Lower - price) / rule.Lower) * 100
+		}
+		return ((price - rule.Upper) / rule.Upper) * 100
+	}
+
+	// Outside rules are waiting for the price to leave the band.
+	toLower := ((price - rule.Lower) / rule.Lower) * 100
+	toUpper := ((rule.Upper - price) / rule.Upper) * 100
+	return math.Min(toLower, toUpper)
+}
+
+// determineNextPollInterval picks how long to sleep before the next poll. When
+// every watched market is closed it sleeps until the first one opens, and
+// symbols whose market is closed never shorten the interval.
+func determineNextPollInterval(quotes map[string]Quote, rules map[string][]AlertRule, baseInterval, nearInterval time.Duration, nearThresholdPercent float64, now time.Time, calendar *marketCalendar) (time.Duration, string) {
 	if nearInterval <= 0 {
 		nearInterval = baseInterval
 	}
@@ -658,38 +768,64 @@ func determineNextPollInterval(prices map[string]float64, rules map[string]Alert
 		nearThresholdPercent = defaultNearThresholdPercent
 	}
 
-	if len(prices) == 0 {
-		return baseInterval, "no successful quotes"
+	// A symbol's market counts as open in extended hours when any of its
+	// rules is not limited to the regular session.
+	symbols := make(map[string]bool, len(rules))
+	for symbol, symbolRules := range rules {
+		symbols[symbol] = false
+		for _, rule := range symbolRules {
+			if rule.Session != sessionRegular {
+				symbols[symbol] = true
+			}
+		}
+	}
+	if opensAt, closed := calendar.allClosedUntil(symbols, now); closed {
+		return opensAt.Sub(now), fmt.Sprintf("all markets closed until %s", opensAt.Local().Format("Mon Jan 2 15:04 MST"))
 	}
 
-	for symbol, price := range prices {
-		rule, ok := rules[symbol]
-		if !ok {
-			continue
-		}
+	if len(quotes) == 0 {
+		return baseInterval, "no successful quotes"
+	}
 
-		if shouldSendAlert(price, rule) {
-			return nearInterval, fmt.Sprintf("%s is in alert condition", symbol)
-		}
+	for symbol, quote := range quotes {
+		for _, rule := range rules[symbol] {
+			if !calendar.isOpen(symbol, now, rule.Session != sessionRegular) || !ruleInSession(rule, quote.Session) {
+				continue
+			}
+			if shouldSendAlert(quote, rule) {
+				return nearInterval, fmt.Sprintf("%s is in alert condition", ruleKey(symbol, rule))
+			}
 
-		if percentDistanceToTrigger(price, rule) <= nearThresholdPercent {
-			return nearInterval, fmt.Sprintf("%s is near threshold (%.2f%%)", symbol, nearThresholdPercent)
+			if percentDistanceToTrigger(quote, rule) <= nearThresholdPercent {
+				return nearInterval, fmt.Sprintf("%s is near threshold (%.2f%%)", ruleKey(symbol, rule), nearThresholdPercent)
+			}
 		}
 	}
 
 	return baseInterval, "all symbols far from threshold"
 }
 
-func shouldNotifyAlert(symbol string, inAlert bool, reminderInterval time.Duration, now time.Time, state map[string]symbolAlertState) bool {
-	current := state[symbol]
+// shouldNotifyAlert advances the alert state for a rule and reports whether a
+// notification is due. rearmed reports whether the price has moved far enough
+// back from the trigger for an active alert to clear; until it has, the alert
+// stays latched so prices oscillating around the threshold do not re-fire.
+func shouldNotifyAlert(key string, inAlert, rearmed bool, reminderInterval time.Duration, now time.Time, state map[string]symbolAlertState) bool {
+	current := state[key]
 
 	if !inAlert {
-		state[symbol] = symbolAlertState{InAlert: false}
+		if current.InAlert && !rearmed {
+			return false
+		}
+		current.InAlert = false
+		current.LastNotifiedUnix = 0
+		state[key] = current
 		return false
 	}
 
 	if !current.InAlert {
-		state[symbol] = symbolAlertState{InAlert: true, LastNotifiedUnix: now.Unix()}
+		current.InAlert = true
+		current.LastNotifiedUnix = now.Unix()
+		state[key] = current
 		return true
 	}
 
@@ -700,98 +836,111 @@ func shouldNotifyAlert(symbol string, inAlert bool, reminderInterval time.Durati
 	lastNotified := time.Unix(current.LastNotifiedUnix, 0)
 	if current.LastNotifiedUnix == 0 || now.Sub(lastNotified) >= reminderInterval {
 		current.LastNotifiedUnix = now.Unix()
-		state[symbol] = current
+		state[key] = current
 		return true
 	}
 
 	return false
 }
 
-func pruneAlertState(alertState map[string]symbolAlertState, rules map[string]AlertRule) {
-	for symbol := range alertState {
-		if _, exists := rules[symbol]; !exists {
-			delete(alertState, symbol)
-		}
+// updateTrailingPeak records a new high-water mark for a trailing stop rule and
+// returns the peak to measure the stop from.
+func updateTrailingPeak(key string, price float64, state map[string]symbolAlertState) float64 {
+	current := state[key]
+	if price > current.PeakPrice {
+		current.PeakPrice = price
+		state[key] = current
 	}
+	return current.PeakPrice
 }
 
-func main() {
-	opts, err := parseCLIOptions()
-	if err != nil {
-		fmt.Println(err)
-		directoryPathHelpMessage()
+func trailingStopLevel(rule AlertRule, peak float64) float64 {
+	if peak <= 0 {
+		return 0
 	}
+	return peak * (1 - rule.TrailPercent/100)
+}
 
-	if opts.Web {
-		if err := runWebUI(opts.Dir, opts.Addr); err != nil {
-			log.Fatalf("Failed to start web UI: %v", err)
-		}
-		return
+// resolveTrailingStop turns a trailing_stop rule into one whose Threshold is
+// the current stop level, so the usual threshold logic can evaluate it.
+func resolveTrailingStop(rule AlertRule, peak float64) AlertRule {
+	if rule.Direction == directionTrailingStop {
+		rule.Threshold = trailingStopLevel(rule, peak)
 	}
+	return rule
+}
 
-	dir := opts.Dir
+// pruneAlertState drops the state of rules that are no longer configured. An
+// alert held for quiet hours outlives its rule so that it still goes out in
+// the next summary; the entry is dropped once it has been released.
+func pruneAlertState(alertState map[string]symbolAlertState, rules map[string][]AlertRule) {
+	carryOverAlertState(alertState, rules)
 
-	settings, err := readAppSettings(dir)
-	if err != nil {
-		log.Printf("Failed to read settings file, using defaults/env: %v", err)
+	active := make(map[string]bool, len(alertState))
+	for symbol, symbolRules := range rules {
+		for _, rule := range symbolRules {
+			active[ruleKey(symbol, rule)] = true
+		}
 	}
-	appSettings = settings
 
-	alertState, err := readAlertState(dir)
-	if err != nil {
-		log.Printf("Failed to read alert state, starting fresh: %v", err)
-		alertState = map[string]symbolAlertState{}
-	}
-	reminderInterval := getReminderIntervalFromEnv()
-	basePollInterval := getDurationWithSetting("STOCKS_NOTIFIER_POLL_INTERVAL", appSettings.PollInterval, defaultPollInterval)
-	nearPollInterval := getDurationWithSetting("STOCKS_NOTIFIER_POLL_NEAR_INTERVAL", appSettings.PollNearInterval, defaultNearInterval)
-	nearThresholdPercent := getNearThresholdPercentFromEnv()
-
-	for {
-		var stocks map[string]AlertRule
-		stocks, err := readJSONData(dir)
-		if err != nil {
-			if notifyErr := notify(fmt.Sprintf("Error: %v", err)); notifyErr != nil {
-				log.Printf("Notify error: %v", notifyErr)
-			}
-			log.Printf("Error: %v", err)
+	for key, current := range alertState {
+		switch {
+		case active[key]:
+		case current.Suppressed != nil:
+			alertState[key] = symbolAlertState{Suppressed: current.Suppressed}
+		default:
+			delete(alertState, key)
 		}
+	}
+}
 
-		prices := make(map[string]float64, len(stocks))
-		for symbol, rule := range stocks {
-
-			price, err := GetStockPrice(symbol)
-			if err != nil {
-				if notifyErr := notify(fmt.Sprintf("Error: %v", err)); notifyErr != nil {
-					log.Printf("Notify error: %v", notifyErr)
-				}
-				log.Printf("Error: %v", err)
-				continue
-			}
-
-			log.Printf("Price of stock %q: %.2f, Alert is set for price %s %.2f\n", symbol, price, rule.Direction, rule.Threshold)
-			prices[symbol] = price
-
-			inAlert := shouldSendAlert(price, rule)
-			if shouldNotifyAlert(symbol, inAlert, reminderInterval, time.Now(), alertState) {
-				alertMessage := fmt.Sprintf("Price of stock %v: %.2f (target %s %.2f)", symbol, price, rule.Direction, rule.Threshold)
-				if notifyErr := notify(alertMessage); notifyErr != nil {
-					log.Printf("Notify error: %v", notifyErr)
-				}
-				// 2 second timeout is needed in MacOS for previous stock notification to get cleared.
-				time.Sleep(2 * time.Second)
+// carryOverAlertState keeps a rule's state when its key changes because its
+// symbol gained or lost rules: a lone unnamed rule is keyed by the bare
+// symbol, but by symbol#<derived id> once the symbol has other rules. State
+// under the bare key records which rule it belongs to so it can follow that
+// rule; state saved before that was recorded goes to the first rule, where
+// "rules add" and the UI leave the existing one. A lone rule that was given
+// an id keeps the state either way.
+func carryOverAlertState(alertState map[string]symbolAlertState, rules map[string][]AlertRule) {
+	for symbol, symbolRules := range rules {
+		if len(symbolRules) == 1 && symbolRules[0].ID == "" {
+			contentID := ruleContentID(symbolRules[0])
+			moveAlertState(alertState, symbol+"#"+contentID, symbol)
+			if current, ok := alertState[symbol]; ok {
+				current.RuleID = contentID
+				alertState[symbol] = current
 			}
-
+			continue
 		}
 
-		pruneAlertState(alertState, stocks)
-		if err := writeAlertState(dir, alertState); err != nil {
-			log.Printf("Failed to persist alert state: %v", err)
+		bare, ok := alertState[symbol]
+		if !ok {
+			continue
 		}
+		for _, rule := range symbolRules {
+			if len(symbolRules) == 1 || bare.RuleID == "" || rule.ID == bare.RuleID {
+				bare.RuleID = ""
+				alertState[symbol] = bare
+				moveAlertState(alertState, symbol, ruleKey(symbol, rule))
+				break
+			}
+		}
+	}
+}
 
-		sleepFor, reason := determineNextPollInterval(prices, stocks, basePollInterval, nearPollInterval, nearThresholdPercent)
-		log.Printf("Sleeping for %s (%s)", sleepFor, reason)
-		time.Sleep(sleepFor)
+// moveAlertState moves the state saved under from to to, unless to already
+// has state of its own.
+func moveAlertState(alertState map[string]symbolAlertState, from, to string) {
+	current, ok := alertState[from]
+	if !ok {
+		return
 	}
+	if _, taken := alertState[to]; !taken {
+		alertState[to] = current
+	}
+	delete(alertState, from)
+}
 
+func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
 }
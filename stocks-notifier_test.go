@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -50,7 +52,8 @@ func TestParseStockRulesSupportsLegacyAndDirectional(t *testing.T) {
 	payload := []byte(`{
 		"AAPL": 180,
 		"TSLA": {"threshold": 250, "direction": "above"},
-		"MSFT": {"threshold": 300}
+		"MSFT": {"threshold": 300},
+		"NVDA": {"threshold": -5, "direction": "change_pct_below"}
 	}`)
 
 	var raw map[string]json.RawMessage
@@ -63,14 +66,17 @@ func TestParseStockRulesSupportsLegacyAndDirectional(t *testing.T) {
 		t.Fatalf("unexpected parse error: %v", err)
 	}
 
-	if rules["AAPL"].Direction != directionBelow || rules["AAPL"].Threshold != 180 {
-		t.Fatalf("legacy format should default to below: %#v", rules["AAPL"])
+	if rules["AAPL"][0].Direction != directionBelow || rules["AAPL"][0].Threshold != 180 {
+		t.Fatalf("legacy format should default to below: %#v", rules["AAPL"][0])
 	}
-	if rules["TSLA"].Direction != directionAbove || rules["TSLA"].Threshold != 250 {
-		t.Fatalf("directional format not parsed correctly: %#v", rules["TSLA"])
+	if rules["TSLA"][0].Direction != directionAbove || rules["TSLA"][0].Threshold != 250 {
+		t.Fatalf("directional format not parsed correctly: %#v", rules["TSLA"][0])
 	}
-	if rules["MSFT"].Direction != directionBelow || rules["MSFT"].Threshold != 300 {
-		t.Fatalf("missing direction should default to below: %#v", rules["MSFT"])
+	if rules["MSFT"][0].Direction != directionBelow || rules["MSFT"][0].Threshold != 300 {
+		t.Fatalf("missing direction should default to below: %#v", rules["MSFT"][0])
+	}
+	if rules["NVDA"][0].Direction != directionChangePctBelow || rules["NVDA"][0].Threshold != -5 {
+		t.Fatalf("change direction not parsed correctly: %#v", rules["NVDA"][0])
 	}
 }
 
@@ -88,35 +94,226 @@ func TestParseStockRulesRejectsInvalidDirection(t *testing.T) {
 	}
 }
 
+func TestParseStockRulesSupportsRuleArrays(t *testing.T) {
+	payload := []byte(`{
+		"AAPL": [
+			{"id": "dip", "threshold": 180},
+			{"threshold": 250, "direction": "above"}
+		]
+	}`)
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		t.Fatalf("failed to unmarshal test payload: %v", err)
+	}
+
+	rules, err := parseStockRules(raw)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+
+	if len(rules["AAPL"]) != 2 {
+		t.Fatalf("expected two rules for AAPL, got: %#v", rules["AAPL"])
+	}
+	if rules["AAPL"][0].ID != "dip" || rules["AAPL"][0].Direction != directionBelow {
+		t.Fatalf("first rule not parsed correctly: %#v", rules["AAPL"][0])
+	}
+	if rules["AAPL"][1].ID != "above-250" || rules["AAPL"][1].Direction != directionAbove {
+		t.Fatalf("missing id should be derived from the rule: %#v", rules["AAPL"][1])
+	}
+	if got := ruleKey("AAPL", rules["AAPL"][0]); got != "AAPL#dip" {
+		t.Fatalf("unexpected rule key: %q", got)
+	}
+}
+
+func TestParseStockRulesRejectsDuplicateIDs(t *testing.T) {
+	raw := map[string]json.RawMessage{
+		"AAPL": json.RawMessage(`[{"id": "a", "threshold": 180}, {"id": "a", "threshold": 170}]`),
+	}
+
+	if _, err := parseStockRules(raw); err == nil {
+		t.Fatalf("expected duplicate rule id error")
+	}
+}
+
+func TestWriteJSONDataRoundTripsRuleArrays(t *testing.T) {
+	dir := t.TempDir()
+	input := map[string][]AlertRule{
+		"TSLA": {{Threshold: 250, Direction: directionAbove}},
+		"AAPL": {
+			{ID: "low", Threshold: 180, Direction: directionBelow},
+			{ID: "high", Threshold: 250, Direction: directionAbove},
+		},
+	}
+
+	if err := writeJSONData(dir, input); err != nil {
+		t.Fatalf("writeJSONData failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "stocks.json"))
+	if err != nil {
+		t.Fatalf("failed to read stocks.json: %v", err)
+	}
+	var compact map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &compact); err != nil {
+		t.Fatalf("stocks.json is not valid JSON: %v", err)
+	}
+	if compact["TSLA"][0] != '{' {
+		t.Fatalf("single unnamed rule should be written as an object, got: %s", compact["TSLA"])
+	}
+
+	output, err := readJSONData(dir)
+	if err != nil {
+		t.Fatalf("readJSONData failed: %v", err)
+	}
+	if len(output["AAPL"]) != 2 || output["AAPL"][1] != input["AAPL"][1] {
+		t.Fatalf("rule array not round-tripped: %#v", output["AAPL"])
+	}
+	if len(output["TSLA"]) != 1 || output["TSLA"][0] != input["TSLA"][0] {
+		t.Fatalf("single rule not round-tripped: %#v", output["TSLA"])
+	}
+}
+
+func TestPruneAlertStatePerRule(t *testing.T) {
+	state := map[string]symbolAlertState{
+		"AAPL#low":  {InAlert: true},
+		"AAPL#high": {InAlert: true},
+		"TSLA":      {InAlert: true},
+	}
+	rules := map[string][]AlertRule{
+		"AAPL": {{ID: "low", Threshold: 180}},
+	}
+
+	pruneAlertState(state, rules)
+
+	if _, ok := state["AAPL#low"]; !ok || len(state) != 1 {
+		t.Fatalf("only state for configured rules should remain: %#v", state)
+	}
+}
+
+func TestAssignRuleIDsDerivesStableIDs(t *testing.T) {
+	rules := []AlertRule{
+		{Threshold: 180, Direction: directionBelow},
+		{ID: "below-180", Threshold: 170, Direction: directionBelow},
+		{Direction: directionOutside, Lower: 380, Upper: 420.5},
+		{Threshold: 180, Direction: directionBelow},
+	}
+	if err := assignRuleIDs(rules); err != nil {
+		t.Fatalf("assignRuleIDs failed: %v", err)
+	}
+	got := []string{rules[0].ID, rules[1].ID, rules[2].ID, rules[3].ID}
+	if fmt.Sprint(got) != "[below-180-2 below-180 outside-380-420.5 below-180-3]" {
+		t.Fatalf("unexpected derived ids: %v", got)
+	}
+
+	// Removing a rule does not rename the others.
+	remaining := []AlertRule{{Direction: directionOutside, Lower: 380, Upper: 420.5}, {Threshold: 250, Direction: directionAbove}}
+	if err := assignRuleIDs(remaining); err != nil || remaining[0].ID != "outside-380-420.5" {
+		t.Fatalf("expected the band rule to keep its id, got %+v (%v)", remaining, err)
+	}
+}
+
+func TestPruneAlertStateFollowsRuleWhenSymbolGainsRules(t *testing.T) {
+	state := map[string]symbolAlertState{"AAPL": {InAlert: true, PeakPrice: 190}}
+	lone := map[string][]AlertRule{"AAPL": {{Threshold: 180, Direction: directionBelow}}}
+	pruneAlertState(state, lone)
+	if state["AAPL"].RuleID != "below-180" {
+		t.Fatalf("expected the lone rule to be recorded: %+v", state)
+	}
+
+	// A rule added in front must not take the existing rule's state.
+	two := map[string][]AlertRule{"AAPL": {{Threshold: 250, Direction: directionAbove}, {Threshold: 180, Direction: directionBelow}}}
+	if err := assignRuleIDs(two["AAPL"]); err != nil {
+		t.Fatalf("assignRuleIDs failed: %v", err)
+	}
+	pruneAlertState(state, two)
+	if current, ok := state["AAPL#below-180"]; !ok || !current.InAlert || current.PeakPrice != 190 || len(state) != 1 {
+		t.Fatalf("expected the state to follow the below-180 rule: %+v", state)
+	}
+
+	// Back to one rule: the state returns to the bare key.
+	pruneAlertState(state, lone)
+	if !state["AAPL"].InAlert || len(state) != 1 {
+		t.Fatalf("expected the state back under the bare symbol: %+v", state)
+	}
+}
+
+func TestWriteJSONDataOmitsDerivedIDs(t *testing.T) {
+	dir := t.TempDir()
+	// The first rule's id is derived with a suffix because the second one
+	// was named like a derived id; only the derived one is left out.
+	rules := map[string][]AlertRule{"AAPL": {
+		{Threshold: 180, Direction: directionBelow},
+		{ID: "below-180", Threshold: 170, Direction: directionBelow},
+		{ID: "dip", Threshold: 150, Direction: directionBelow},
+	}}
+	if err := assignRuleIDs(rules["AAPL"]); err != nil {
+		t.Fatalf("assignRuleIDs failed: %v", err)
+	}
+	if err := writeJSONData(dir, rules); err != nil {
+		t.Fatalf("writeJSONData failed: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "stocks.json"))
+	if err != nil {
+		t.Fatalf("failed to read stocks.json: %v", err)
+	}
+	if strings.Contains(string(raw), "below-180-2") || !strings.Contains(string(raw), `"below-180"`) || !strings.Contains(string(raw), `"dip"`) {
+		t.Fatalf("expected only explicit ids to be written: %s", raw)
+	}
+}
+
 func TestShouldSendAlert(t *testing.T) {
-	if !shouldSendAlert(100, AlertRule{Threshold: 110, Direction: directionBelow}) {
+	if !shouldSendAlert(Quote{Price: 100}, AlertRule{Threshold: 110, Direction: directionBelow}) {
 		t.Fatalf("below alert should trigger when price is lower")
 	}
-	if shouldSendAlert(120, AlertRule{Threshold: 110, Direction: directionBelow}) {
+	if shouldSendAlert(Quote{Price: 120}, AlertRule{Threshold: 110, Direction: directionBelow}) {
 		t.Fatalf("below alert should not trigger when price is higher")
 	}
-	if !shouldSendAlert(120, AlertRule{Threshold: 110, Direction: directionAbove}) {
+	if !shouldSendAlert(Quote{Price: 120}, AlertRule{Threshold: 110, Direction: directionAbove}) {
 		t.Fatalf("above alert should trigger when price is higher")
 	}
-	if shouldSendAlert(100, AlertRule{Threshold: 110, Direction: directionAbove}) {
+	if shouldSendAlert(Quote{Price: 100}, AlertRule{Threshold: 110, Direction: directionAbove}) {
 		t.Fatalf("above alert should not trigger when price is lower")
 	}
 }
 
+func TestShouldSendAlertChangePercent(t *testing.T) {
+	down := -6.5
+	up := 3.0
+	dropRule := AlertRule{Threshold: -5, Direction: directionChangePctBelow}
+	riseRule := AlertRule{Threshold: 5, Direction: directionChangePctAbove}
+
+	if !shouldSendAlert(Quote{Price: 100, ChangePercent: &down}, dropRule) {
+		t.Fatalf("change_pct_below should trigger when the day's drop exceeds the threshold")
+	}
+	if shouldSendAlert(Quote{Price: 100, ChangePercent: &up}, dropRule) {
+		t.Fatalf("change_pct_below should not trigger on a gain")
+	}
+	if shouldSendAlert(Quote{Price: 100, ChangePercent: &up}, riseRule) {
+		t.Fatalf("change_pct_above should not trigger below the threshold")
+	}
+	if shouldSendAlert(Quote{Price: 100}, dropRule) {
+		t.Fatalf("change rules should not trigger when the provider has no change data")
+	}
+	if got := percentDistanceToTrigger(Quote{Price: 100, ChangePercent: &up}, riseRule); got != 2 {
+		t.Fatalf("expected 2 percentage points to trigger, got: %v", got)
+	}
+}
+
 func TestShouldNotifyAlertStateTransitions(t *testing.T) {
 	state := map[string]symbolAlertState{}
 	now := time.Unix(1_700_000_000, 0)
 
-	if !shouldNotifyAlert("AAPL", true, 0, now, state) {
+	if !shouldNotifyAlert("AAPL", true, false, 0, now, state) {
 		t.Fatalf("first entry into alert should notify")
 	}
-	if shouldNotifyAlert("AAPL", true, 0, now.Add(time.Minute), state) {
+	if shouldNotifyAlert("AAPL", true, false, 0, now.Add(time.Minute), state) {
 		t.Fatalf("same alert state should not notify repeatedly when reminders are disabled")
 	}
-	if shouldNotifyAlert("AAPL", false, 0, now.Add(2*time.Minute), state) {
+	if shouldNotifyAlert("AAPL", false, true, 0, now.Add(2*time.Minute), state) {
 		t.Fatalf("exiting alert should not notify")
 	}
-	if !shouldNotifyAlert("AAPL", true, 0, now.Add(3*time.Minute), state) {
+	if !shouldNotifyAlert("AAPL", true, false, 0, now.Add(3*time.Minute), state) {
 		t.Fatalf("re-entering alert should notify again")
 	}
 }
@@ -125,22 +322,95 @@ func TestShouldNotifyAlertReminderInterval(t *testing.T) {
 	state := map[string]symbolAlertState{}
 	now := time.Unix(1_700_000_000, 0)
 
-	if !shouldNotifyAlert("AAPL", true, 2*time.Hour, now, state) {
+	if !shouldNotifyAlert("AAPL", true, false, 2*time.Hour, now, state) {
 		t.Fatalf("first entry should notify")
 	}
-	if shouldNotifyAlert("AAPL", true, 2*time.Hour, now.Add(time.Hour), state) {
+	if shouldNotifyAlert("AAPL", true, false, 2*time.Hour, now.Add(time.Hour), state) {
 		t.Fatalf("should not remind before interval")
 	}
-	if !shouldNotifyAlert("AAPL", true, 2*time.Hour, now.Add(2*time.Hour), state) {
+	if !shouldNotifyAlert("AAPL", true, false, 2*time.Hour, now.Add(2*time.Hour), state) {
 		t.Fatalf("should remind when interval elapses")
 	}
 }
 
+func TestShouldNotifyAlertHysteresis(t *testing.T) {
+	state := map[string]symbolAlertState{}
+	now := time.Unix(1_700_000_000, 0)
+	rule := AlertRule{Threshold: 180, Direction: directionBelow, RearmPercent: 1}
+	step := func(price float64) bool {
+		now = now.Add(time.Minute)
+		quote := Quote{Price: price}
+		rearmed := percentDistanceToTrigger(quote, rule) >= effectiveRearmPercent(rule, 0)
+		return shouldNotifyAlert("AAPL", shouldSendAlert(quote, rule), rearmed, 0, now, state)
+	}
+
+	if !step(179.9) {
+		t.Fatalf("first crossing should notify")
+	}
+	if step(180.1) {
+		t.Fatalf("moving back inside the re-arm margin should not notify")
+	}
+	if !state["AAPL"].InAlert {
+		t.Fatalf("alert should stay latched inside the re-arm margin")
+	}
+	if step(179.95) {
+		t.Fatalf("re-crossing before re-arm should not notify again")
+	}
+	if step(182) || state["AAPL"].InAlert {
+		t.Fatalf("moving past the re-arm margin should clear the alert without notifying")
+	}
+	if !step(179.5) {
+		t.Fatalf("crossing again after re-arm should notify")
+	}
+}
+
+func TestShouldNotifyAlertChangeRuleRearmsInPercentagePoints(t *testing.T) {
+	state := map[string]symbolAlertState{}
+	now := time.Unix(1_700_000_000, 0)
+	rule := AlertRule{Threshold: -5, Direction: directionChangePctBelow, RearmPercent: 1}
+	step := func(change float64) bool {
+		now = now.Add(time.Minute)
+		quote := Quote{Price: 100, ChangePercent: &change}
+		rearmed := percentDistanceToTrigger(quote, rule) >= effectiveRearmPercent(rule, 0)
+		return shouldNotifyAlert("NVDA", shouldSendAlert(quote, rule), rearmed, 0, now, state)
+	}
+
+	if !step(-5.5) {
+		t.Fatalf("first crossing should notify")
+	}
+	// -4.5% is 0.5 points from the threshold, inside the 1 point margin; as a
+	// percent of the threshold it would already be 10% away.
+	if step(-4.5) || !state["NVDA"].InAlert {
+		t.Fatalf("alert should stay latched within 1 percentage point")
+	}
+	if step(-5.2) {
+		t.Fatalf("re-crossing before re-arm should not notify again")
+	}
+	if step(-3.9) || state["NVDA"].InAlert {
+		t.Fatalf("moving more than 1 percentage point back should re-arm")
+	}
+	if !step(-5.1) {
+		t.Fatalf("crossing again after re-arm should notify")
+	}
+}
+
+func TestEffectiveRearmPercent(t *testing.T) {
+	if got := effectiveRearmPercent(AlertRule{}, 0); got != 0 {
+		t.Fatalf("expected no re-arm margin by default, got: %v", got)
+	}
+	if got := effectiveRearmPercent(AlertRule{}, 1.5); got != 1.5 {
+		t.Fatalf("expected global default, got: %v", got)
+	}
+	if got := effectiveRearmPercent(AlertRule{RearmPercent: 3}, 1.5); got != 3 {
+		t.Fatalf("expected per-rule override, got: %v", got)
+	}
+}
+
 func TestReadWriteAlertState(t *testing.T) {
 	dir := t.TempDir()
 	input := map[string]symbolAlertState{
 		"AAPL": {InAlert: true, LastNotifiedUnix: 1_700_000_000},
-		"TSLA": {InAlert: false},
+		"TSLA": {InAlert: false, PeakPrice: 251.5},
 	}
 
 	if err := writeAlertState(dir, input); err != nil {
@@ -228,62 +498,129 @@ func TestPercentDistanceToTrigger(t *testing.T) {
 	belowRule := AlertRule{Threshold: 100, Direction: directionBelow}
 	aboveRule := AlertRule{Threshold: 100, Direction: directionAbove}
 
-	if got := percentDistanceToTrigger(95, belowRule); got != 0 {
+	if got := percentDistanceToTrigger(Quote{Price: 95}, belowRule); got != 0 {
 		t.Fatalf("expected zero distance when below-rule is already in alert, got: %v", got)
 	}
-	if got := percentDistanceToTrigger(105, belowRule); got != 5 {
+	if got := percentDistanceToTrigger(Quote{Price: 105}, belowRule); got != 5 {
 		t.Fatalf("expected 5 percent distance, got: %v", got)
 	}
-	if got := percentDistanceToTrigger(105, aboveRule); got != 0 {
+	if got := percentDistanceToTrigger(Quote{Price: 105}, aboveRule); got != 0 {
 		t.Fatalf("expected zero distance when above-rule is already in alert, got: %v", got)
 	}
-	if got := percentDistanceToTrigger(95, aboveRule); got != 5 {
+	if got := percentDistanceToTrigger(Quote{Price: 95}, aboveRule); got != 5 {
 		t.Fatalf("expected 5 percent distance, got: %v", got)
 	}
 }
 
+func TestBandRules(t *testing.T) {
+	between := AlertRule{Direction: directionBetween, Lower: 100, Upper: 120}
+	outside := AlertRule{Direction: directionOutside, Lower: 100, Upper: 120}
+
+	if !shouldSendAlert(Quote{Price: 110}, between) || shouldSendAlert(Quote{Price: 95}, between) {
+		t.Fatalf("between rule should only trigger inside the band")
+	}
+	if shouldSendAlert(Quote{Price: 110}, outside) || !shouldSendAlert(Quote{Price: 121}, outside) || !shouldSendAlert(Quote{Price: 99}, outside) {
+		t.Fatalf("outside rule should only trigger outside the band")
+	}
+
+	if got := percentDistanceToTrigger(Quote{Price: 95}, between); got != 5 {
+		t.Fatalf("expected 5 percent to the lower bound, got: %v", got)
+	}
+	if got := percentDistanceToTrigger(Quote{Price: 102}, outside); got != 2 {
+		t.Fatalf("expected 2 percent to the nearest bound, got: %v", got)
+	}
+	if got := percentDistanceToTrigger(Quote{Price: 117.6}, outside); got < 1.99 || got > 2.01 {
+		t.Fatalf("expected about 2 percent to the upper bound, got: %v", got)
+	}
+
+	rules := map[string][]AlertRule{"MSFT": {outside}}
+	interval, reason := determineNextPollInterval(map[string]Quote{"MSFT": {Price: 101}}, rules, 10*time.Minute, 2*time.Minute, 2, time.Now(), nil)
+	if interval != 2*time.Minute || reason != "MSFT is near threshold (2.00%)" {
+		t.Fatalf("band rule near a bound should use near interval, got %v (%s)", interval, reason)
+	}
+
+	invalid := AlertRule{Direction: directionBetween, Lower: 120, Upper: 100}
+	if err := invalid.normalize(); err == nil {
+		t.Fatalf("expected error when lower is not below upper")
+	}
+}
+
+func TestTrailingStop(t *testing.T) {
+	state := map[string]symbolAlertState{}
+	rule := AlertRule{Direction: directionTrailingStop, TrailPercent: 10}
+	now := time.Unix(1_700_000_000, 0)
+
+	step := func(price float64) (AlertRule, bool) {
+		now = now.Add(time.Minute)
+		resolved := resolveTrailingStop(rule, updateTrailingPeak("TSLA", price, state))
+		inAlert := shouldSendAlert(Quote{Price: price}, resolved)
+		return resolved, shouldNotifyAlert("TSLA", inAlert, true, 0, now, state)
+	}
+
+	if _, notified := step(200); notified {
+		t.Fatalf("first price should only set the peak")
+	}
+	if resolved, notified := step(250); notified || resolved.Threshold != 225 {
+		t.Fatalf("new high should raise the stop to 225, got %v (notified=%v)", resolved.Threshold, notified)
+	}
+	if _, notified := step(230); notified || state["TSLA"].PeakPrice != 250 {
+		t.Fatalf("dip above the stop should not notify or lower the peak: %#v", state["TSLA"])
+	}
+	if _, notified := step(224); !notified {
+		t.Fatalf("falling through the stop should notify")
+	}
+	if _, notified := step(240); notified || state["TSLA"].InAlert || state["TSLA"].PeakPrice != 250 {
+		t.Fatalf("recovering should clear the alert but keep the peak: %#v", state["TSLA"])
+	}
+
+	invalid := AlertRule{Direction: directionTrailingStop}
+	if err := invalid.normalize(); err == nil {
+		t.Fatalf("expected error for trailing stop without trailPercent")
+	}
+}
+
 func TestDetermineNextPollInterval(t *testing.T) {
 	base := 10 * time.Minute
 	near := 2 * time.Minute
 	nearPct := 2.0
-	rules := map[string]AlertRule{
-		"AAPL": {Threshold: 100, Direction: directionBelow},
+	rules := map[string][]AlertRule{
+		"AAPL": {{Threshold: 100, Direction: directionBelow}},
 	}
 
 	tests := []struct {
 		name         string
-		prices       map[string]float64
+		quotes       map[string]Quote
 		expect       time.Duration
 		expectReason string
 	}{
 		{
 			name:         "no prices uses base",
-			prices:       map[string]float64{},
+			quotes:       map[string]Quote{},
 			expect:       base,
 			expectReason: "no successful quotes",
 		},
 		{
 			name:         "in alert uses near",
-			prices:       map[string]float64{"AAPL": 99},
+			quotes:       map[string]Quote{"AAPL": {Price: 99}},
 			expect:       near,
 			expectReason: "AAPL is in alert condition",
 		},
 		{
 			name:         "near threshold uses near",
-			prices:       map[string]float64{"AAPL": 101},
+			quotes:       map[string]Quote{"AAPL": {Price: 101}},
 			expect:       near,
 			expectReason: "AAPL is near threshold (2.00%)",
 		},
 		{
 			name:         "far threshold uses base",
-			prices:       map[string]float64{"AAPL": 110},
+			quotes:       map[string]Quote{"AAPL": {Price: 110}},
 			expect:       base,
 			expectReason: "all symbols far from threshold",
 		},
 	}
 
 	for _, tt := range tests {
-		gotInterval, gotReason := determineNextPollInterval(tt.prices, rules, base, near, nearPct)
+		gotInterval, gotReason := determineNextPollInterval(tt.quotes, rules, base, near, nearPct, time.Now(), nil)
 		if gotInterval != tt.expect {
 			t.Fatalf("%s: expected interval %v, got %v", tt.name, tt.expect, gotInterval)
 		}
@@ -292,3 +629,14 @@ func TestDetermineNextPollInterval(t *testing.T) {
 		}
 	}
 }
+
+func TestAlertRuleSeverityValidation(t *testing.T) {
+	rule := AlertRule{Threshold: 100, Severity: " HIGH "}
+	if err := rule.normalize(); err != nil || rule.Severity != severityHigh {
+		t.Fatalf("expected severity to normalize to high, got %q (%v)", rule.Severity, err)
+	}
+	rule = AlertRule{Threshold: 100, Severity: "critical"}
+	if err := rule.normalize(); err == nil {
+		t.Fatalf("expected unknown severity to be rejected")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MessageTemplates overrides the title and body of notifications with Go
+// text/template strings. Empty fields keep the built-in text.
+type MessageTemplates struct {
+	AlertTitle string `json:"alertTitle,omitempty"`
+	AlertBody  string `json:"alertBody,omitempty"`
+	ErrorTitle string `json:"errorTitle,omitempty"`
+	ErrorBody  string `json:"errorBody,omitempty"`
+}
+
+// templateData is the value templates are executed against.
+type templateData struct {
+	Kind    string
+	Title   string
+	Message string
+	Symbol  string
+	RuleKey string
+	Rule    *AlertRule
+	Quote   *Quote
+	// Distance is how far the quote is from triggering the rule, as
+	// reported by percentDistanceToTrigger. Zero once the rule has fired.
+	Distance   float64
+	Error      string
+	ErrorClass string
+	Time       time.Time
+}
+
+var templateFuncs = template.FuncMap{
+	"fixed": func(digits int, value any) (string, error) {
+		switch v := value.(type) {
+		case float64:
+			return fmt.Sprintf("%.*f", digits, v), nil
+		case *float64:
+			if v == nil {
+				return "", nil
+			}
+			return fmt.Sprintf("%.*f", digits, *v), nil
+		default:
+			return "", fmt.Errorf("fixed: unsupported value %T", value)
+		}
+	},
+	"describeRule": describeRule,
+	"quoteSummary": formatQuoteSummary,
+	"upper":        strings.ToUpper,
+}
+
+type compiledTemplates struct {
+	alertTitle *template.Template
+	alertBody  *template.Template
+	errorTitle *template.Template
+	errorBody  *template.Template
+}
+
+// mergeTemplates layers a channel's templates over the global ones field by
+// field.
+func mergeTemplates(global, channel *MessageTemplates) MessageTemplates {
+	var merged MessageTemplates
+	if global != nil {
+		merged = *global
+	}
+	if channel == nil {
+		return merged
+	}
+	if channel.AlertTitle != "" {
+		merged.AlertTitle = channel.AlertTitle
+	}
+	if channel.AlertBody != "" {
+		merged.AlertBody = channel.AlertBody
+	}
+	if channel.ErrorTitle != "" {
+		merged.ErrorTitle = channel.ErrorTitle
+	}
+	if channel.ErrorBody != "" {
+		merged.ErrorBody = channel.ErrorBody
+	}
+	return merged
+}
+
+// compileTemplates parses every configured template and executes it against
+// sample alert and error notifications, so unknown fields and bad function
+// calls are reported at startup rather than when an alert fires. It returns
+// nil when no template is configured.
+func compileTemplates(cfg MessageTemplates) (*compiledTemplates, error) {
+	if cfg == (MessageTemplates{}) {
+		return nil, nil
+	}
+
+	compiled := &compiledTemplates{}
+	sampleAlert := sampleAlertNotification()
+	sampleError := newErrorNotification("AAPL", fmt.Errorf("sample error"), sampleAlert.Time)
+	for _, field := range []struct {
+		name   string
+		text   string
+		target **template.Template
+		sample Notification
+	}{
+		{"alertTitle", cfg.AlertTitle, &compiled.alertTitle, sampleAlert},
+		{"alertBody", cfg.AlertBody, &compiled.alertBody, sampleAlert},
+		{"errorTitle", cfg.ErrorTitle, &compiled.errorTitle, sampleError},
+		{"errorBody", cfg.ErrorBody, &compiled.errorBody, sampleError},
+	} {
+		if strings.TrimSpace(field.text) == "" {
+			continue
+		}
+		tmpl, err := template.New(field.name).Funcs(templateFuncs).Option("missingkey=error").Parse(field.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %v", field.name, err)
+		}
+		if _, err := executeTemplate(tmpl, newTemplateData(field.sample)); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %v", field.name, err)
+		}
+		*field.target = tmpl
+	}
+	return compiled, nil
+}
+
+func sampleAlertNotification() Notification {
+	changePercent := -1.16
+	quote := Quote{Symbol: "AAPL", Name: "Apple Inc.", Price: 178.2, ChangePercent: &changePercent, Source: providerStockpricesDev}
+	return newAlertNotification("AAPL", AlertRule{Threshold: 180, Direction: directionBelow}, quote, time.Unix(1_700_000_000, 0).UTC())
+}
+
+func newTemplateData(n Notification) templateData {
+	data := templateData{
+		Kind:       n.Kind,
+		Title:      n.Title,
+		Message:    n.Message,
+		Symbol:     n.Symbol,
+		RuleKey:    n.RuleKey,
+		Rule:       n.Rule,
+		Quote:      n.Quote,
+		Error:      n.Error,
+		ErrorClass: n.ErrorClass,
+		Time:       n.Time,
+	}
+	if n.Rule != nil && n.Quote != nil {
+		data.Distance = percentDistanceToTrigger(*n.Quote, *n.Rule)
+	}
+	return data
+}
+
+func executeTemplate(tmpl *template.Template, data templateData) (string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// render returns n with its title and message replaced by the matching
+// templates. A template that fails at send time leaves the built-in text in
+// place so the notification still goes out.
+func (c *compiledTemplates) render(n Notification) (Notification, error) {
+	var title, body *template.Template
+	switch n.Kind {
+	case notificationAlert, notificationTest:
+		title, body = c.alertTitle, c.alertBody
+	case notificationError:
+		title, body = c.errorTitle, c.errorBody
+	case notificationDigest:
+		return c.renderDigest(n)
+	default:
+		return n, nil
+	}
+
+	data := newTemplateData(n)
+	rendered := n
+	if title != nil {
+		text, err := executeTemplate(title, data)
+		if err != nil {
+			return n, err
+		}
+		rendered.Title = text
+	}
+	if body != nil {
+		text, err := executeTemplate(body, data)
+		if err != nil {
+			return n, err
+		}
+		rendered.Message = text
+	}
+	return rendered, nil
+}
+
+// renderDigest applies the alert templates to each alert of a digest and
+// rebuilds the digest text from the results.
+func (c *compiledTemplates) renderDigest(n Notification) (Notification, error) {
+	rendered := n
+	rendered.Alerts = make([]Notification, len(n.Alerts))
+	for i, alert := range n.Alerts {
+		item, err := c.render(alert)
+		if err != nil {
+			return n, err
+		}
+		rendered.Alerts[i] = item
+	}
+	rendered.Message = digestMessage(rendered.Alerts)
+	return rendered, nil
+}
+
+// hasCustomTitle reports whether n carries a title rendered from a template
+// rather than the built-in one.
+func hasCustomTitle(n Notification) bool {
+	return n.Title != "" && n.Title != notificationTitle
+}
+
+// templatedNotifier renders a channel's templates before delegating to it.
+type templatedNotifier struct {
+	Notifier
+	templates *compiledTemplates
+}
+
+func (t *templatedNotifier) Notify(n Notification) error {
+	rendered, err := t.templates.render(n)
+	if err != nil {
+		log.Printf("Template for %s failed, sending default text: %v", t.Name(), err)
+	}
+	return t.Notifier.Notify(markTestNotification(rendered))
+}
+
+// FlushOutbox keeps queued deliveries working for wrapped channels.
+func (t *templatedNotifier) FlushOutbox(channels map[string]string) error {
+	if flusher, ok := t.Notifier.(outboxFlusher); ok {
+		return flusher.FlushOutbox(channels)
+	}
+	return nil
+}
+
+func (t *templatedNotifier) waitQueued() {
+	if queued, ok := t.Notifier.(queuedNotifier); ok {
+		queued.waitQueued()
+	}
+}
+
+func (t *templatedNotifier) outboxKey() string {
+	if flusher, ok := t.Notifier.(outboxFlusher); ok {
+		return flusher.outboxKey()
+	}
+	return ""
+}
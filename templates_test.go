@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTemplatesRenderAlertAndError(t *testing.T) {
+	compiled, err := compileTemplates(MessageTemplates{
+		AlertTitle: "{{.Symbol}} {{.Rule.Direction}} {{fixed 2 .Rule.Threshold}}",
+		AlertBody:  "{{.Symbol}} at {{fixed 2 .Quote.Price}} ({{fixed 1 .Quote.ChangePercent}}%), {{fixed 1 .Distance}}% from trigger at {{.Time.Format \"15:04\"}}",
+		ErrorBody:  "monitor problem: {{.Error}}",
+	})
+	if err != nil {
+		t.Fatalf("compileTemplates failed: %v", err)
+	}
+
+	n := sampleAlertNotification()
+	rendered, err := compiled.render(n)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if rendered.Title != "AAPL below 180.00" {
+		t.Fatalf("unexpected title: %q", rendered.Title)
+	}
+	if rendered.Message != "AAPL at 178.20 (-1.2%), 0.0% from trigger at 22:13" {
+		t.Fatalf("unexpected message: %q", rendered.Message)
+	}
+
+	rendered, err = compiled.render(newErrorNotification("", errors.New("quota exceeded"), n.Time))
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if rendered.Title != notificationTitle || rendered.Message != "monitor problem: quota exceeded" {
+		t.Fatalf("unexpected error notification: %+v", rendered)
+	}
+}
+
+func TestTemplatesRejectedAtStartup(t *testing.T) {
+	cases := []struct {
+		templates MessageTemplates
+		want      string
+	}{
+		{MessageTemplates{AlertBody: "{{.Symbol"}, "invalid alertBody template"},
+		{MessageTemplates{AlertTitle: "{{.Ticker}}"}, "invalid alertTitle template"},
+		{MessageTemplates{ErrorBody: "{{nope .Error}}"}, "invalid errorBody template"},
+	}
+	for _, tc := range cases {
+		if _, err := compileTemplates(tc.templates); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected error containing %q, got: %v", tc.want, err)
+		}
+	}
+
+	channels := []ChannelSettings{{Type: channelDesktop, Templates: &MessageTemplates{AlertBody: "{{.Price}}"}}}
+	if _, err := buildNotifiers(t.TempDir(), channels, nil); err == nil || !strings.Contains(err.Error(), `channel "desktop"`) {
+		t.Fatalf("expected channel template error, got: %v", err)
+	}
+}
+
+func TestChannelTemplatesOverrideGlobal(t *testing.T) {
+	global := &MessageTemplates{AlertTitle: "global {{.Symbol}}", AlertBody: "global body"}
+	channels := []ChannelSettings{
+		{Type: "recording", Name: "plain"},
+		{Type: "recording", Name: "custom", Templates: &MessageTemplates{AlertBody: "custom {{.RuleKey}}"}},
+	}
+
+	recorders := map[string]*recordingNotifier{}
+	registerNotifier("recording", func(_, name string, _ ChannelSettings) (Notifier, error) {
+		recorders[name] = &recordingNotifier{name: name}
+		return recorders[name], nil
+	})
+	defer delete(notifierFactories, "recording")
+
+	notifiers, err := buildNotifiers(t.TempDir(), channels, global)
+	if err != nil {
+		t.Fatalf("buildNotifiers failed: %v", err)
+	}
+	dispatchNotification(notifiers, sampleAlertNotification())
+
+	plain, custom := recorders["plain"].sent, recorders["custom"].sent
+	if len(plain) != 1 || plain[0].Title != "global AAPL" || plain[0].Message != "global body" {
+		t.Fatalf("unexpected plain channel notification: %+v", plain)
+	}
+	if len(custom) != 1 || custom[0].Title != "global AAPL" || custom[0].Message != "custom AAPL" {
+		t.Fatalf("unexpected custom channel notification: %+v", custom)
+	}
+}
@@ -4,22 +4,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
 type configPayload struct {
-	Rules    map[string]AlertRule `json:"rules"`
-	Settings AppSettings          `json:"settings"`
+	Rules    map[string][]AlertRule `json:"rules"`
+	Settings AppSettings            `json:"settings"`
+	// Keys lists the state key of each rule, in order. Rules are served
+	// without derived ids, so the UI needs it to show their status.
+	Keys map[string][]string `json:"keys,omitempty"`
 }
 
-type quoteCheckResult struct {
-	Symbol string   `json:"symbol"`
-	Price  *float64 `json:"price,omitempty"`
-	Error  string   `json:"error,omitempty"`
+type ruleStatus struct {
+	InAlert   bool    `json:"inAlert"`
+	PeakPrice float64 `json:"peakPrice,omitempty"`
+	StopLevel float64 `json:"stopLevel,omitempty"`
+	// Held is set while an alert is waiting for quiet hours to end.
+	Held bool `json:"held,omitempty"`
 }
 
-func runWebUI(dir, addr string) error {
+// startWebUI serves the configuration UI in the background. When monitor is
+// not nil the UI shares its in-memory state, shows its live status and makes
+// it reload after every save.
+func startWebUI(dir, addr string, monitor *Monitor) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: newWebUIMux(dir, monitor)}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Web UI stopped: %v", err)
+		}
+	}()
+	log.Printf("Stocks Notifier UI available at http://%s", addr)
+	return server, nil
+}
+
+// newWebUIMux routes the UI and its API. Every route, reads included, is
+// refused unless the Host is localhost or an IP address, so a rebound DNS name
+// cannot make another site same-origin and read the config or state.
+func newWebUIMux(dir string, monitor *Monitor) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -33,92 +63,353 @@ func runWebUI(dir, addr string) error {
 	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			handleGetConfig(dir, w)
+			handleGetConfig(dir, w, monitor)
 		case http.MethodPost:
-			handleSaveConfig(dir, w, r)
+			if !checkStateChangeRequest(w, r) {
+				return
+			}
+			if handleSaveConfig(dir, w, r) {
+				monitor.Reload()
+			}
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
+	mux.HandleFunc("/api/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleGetState(dir, w, monitor)
+	})
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		respondJSON(w, http.StatusOK, monitor.snapshot())
+	})
+
 	mux.HandleFunc("/api/check", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		handleCheckQuotes(dir, w)
+		if !checkStateChangeRequest(w, r) {
+			return
+		}
+		handleCheckQuotes(dir, w, r, monitor)
 	})
 
-	log.Printf("Stocks Notifier UI available at http://%s", addr)
-	return http.ListenAndServe(addr, mux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := validateHost(r); err != nil {
+			respondJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkStateChangeRequest rejects POSTs that other web pages could forge. A
+// JSON content type cannot be sent cross-origin without a CORS preflight,
+// which the UI never answers. The Host must be localhost or an IP address,
+// and a browser's Origin must match it. Clients that send no Origin, such as
+// curl, are not browsers and cannot be forged this way.
+func checkStateChangeRequest(w http.ResponseWriter, r *http.Request) bool {
+	if err := validateStateChangeRequest(r); err != nil {
+		respondJSONError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
 }
 
-func handleGetConfig(dir string, w http.ResponseWriter) {
+func validateStateChangeRequest(r *http.Request) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return fmt.Errorf("requests must be sent as application/json")
+	}
+	if err := validateHost(r); err != nil {
+		return err
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(parsed.Host, r.Host) {
+			return fmt.Errorf("cross-origin request from %q refused", origin)
+		}
+	}
+	return nil
+}
+
+// validateHost accepts only localhost or an IP address as the Host.
+func validateHost(r *http.Request) error {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+	if !strings.EqualFold(host, "localhost") && net.ParseIP(host) == nil {
+		return fmt.Errorf("host %q is not allowed; open the UI by IP address or localhost", r.Host)
+	}
+	return nil
+}
+
+// handleGetConfig serves the rules and settings the running monitor polled
+// with, or the saved ones when there is no monitor or it has not polled yet.
+func handleGetConfig(dir string, w http.ResponseWriter, monitor *Monitor) {
+	status := monitor.snapshot()
+	rules, settings := status.rules, status.settings
+	if rules == nil {
+		var err error
+		if rules, err = readJSONData(dir); err != nil {
+			respondJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if settings, err = readAppSettings(dir); err != nil {
+			respondJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// The monitor's rules are shared, so serve copies.
+	served := make(map[string][]AlertRule, len(rules))
+	keys := make(map[string][]string, len(rules))
+	for symbol, symbolRules := range rules {
+		for _, rule := range symbolRules {
+			keys[symbol] = append(keys[symbol], ruleKey(symbol, rule))
+			served[symbol] = append(served[symbol], withoutDerivedID(rule))
+		}
+	}
+
+	respondJSON(w, http.StatusOK, configPayload{
+		Rules:    served,
+		Settings: settings,
+		Keys:     keys,
+	})
+}
+
+// handleGetState reports rule status from the running monitor's memory, or
+// from the state file when there is no monitor or it has not polled yet.
+func handleGetState(dir string, w http.ResponseWriter, monitor *Monitor) {
+	if status := monitor.snapshot(); status.rules != nil {
+		respondJSON(w, http.StatusOK, buildRuleStatuses(status.rules, status.state))
+		return
+	}
+
 	rules, err := readJSONData(dir)
 	if err != nil {
 		respondJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	settings, err := readAppSettings(dir)
+	state, err := readAlertState(dir)
 	if err != nil {
 		respondJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, configPayload{
-		Rules:    rules,
-		Settings: settings,
-	})
+	respondJSON(w, http.StatusOK, buildRuleStatuses(rules, state))
+}
+
+func buildRuleStatuses(rules map[string][]AlertRule, state map[string]symbolAlertState) map[string]ruleStatus {
+	statuses := make(map[string]ruleStatus)
+	for symbol, symbolRules := range rules {
+		for _, rule := range symbolRules {
+			key := ruleKey(symbol, rule)
+			current := state[key]
+			status := ruleStatus{InAlert: current.InAlert, Held: current.Suppressed != nil}
+			if rule.Direction == directionTrailingStop {
+				status.PeakPrice = current.PeakPrice
+				status.StopLevel = trailingStopLevel(rule, current.PeakPrice)
+			}
+			statuses[key] = status
+		}
+	}
+	return statuses
 }
 
-func handleSaveConfig(dir string, w http.ResponseWriter, r *http.Request) {
+// handleSaveConfig validates and writes the rules and settings, reporting
+// whether they were saved.
+func handleSaveConfig(dir string, w http.ResponseWriter, r *http.Request) bool {
 	defer r.Body.Close()
 
 	var payload configPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON payload: %v", err))
-		return
+		return false
 	}
 
 	if payload.Rules == nil {
-		payload.Rules = map[string]AlertRule{}
+		payload.Rules = map[string][]AlertRule{}
 	}
 
-	normalizedRules := make(map[string]AlertRule, len(payload.Rules))
-	for symbol, rule := range payload.Rules {
+	normalizedRules := make(map[string][]AlertRule, len(payload.Rules))
+	for symbol, symbolRules := range payload.Rules {
 		symbol = strings.TrimSpace(strings.ToUpper(symbol))
 		if symbol == "" {
 			respondJSONError(w, http.StatusBadRequest, "symbol cannot be empty")
-			return
+			return false
 		}
-		if rule.Threshold <= 0 {
-			respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid threshold for %s", symbol))
-			return
+		for _, rule := range symbolRules {
+			if err := rule.normalize(); err != nil {
+				respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid rule for %s: %v", symbol, err))
+				return false
+			}
+			if rule.RearmPercent < 0 {
+				respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid re-arm percent for %s", symbol))
+				return false
+			}
+			if err := validateRuleValues(rule); err != nil {
+				respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("%v for %s", err, symbol))
+				return false
+			}
+			normalizedRules[symbol] = append(normalizedRules[symbol], rule)
 		}
-		if err := rule.normalize(); err != nil {
-			respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid direction for %s: %v", symbol, err))
-			return
+	}
+
+	for symbol, symbolRules := range normalizedRules {
+		if err := assignRuleIDs(symbolRules); err != nil {
+			respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid rules for %s: %v", symbol, err))
+			return false
 		}
-		normalizedRules[symbol] = rule
+	}
+
+	if _, err := resolveProviderChain(payload.Settings.Providers); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid provider chain: %v", err))
+		return false
+	}
+
+	if err := validateProviderLimits(payload.Settings.ProviderLimits); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if _, err := newQuietSchedule(payload.Settings.QuietHours); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if _, err := newAlertDigest(payload.Settings.Digest); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if err := carryOverLockedSettings(dir, &payload.Settings); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if _, err := newMarketCalendar(dir, payload.Settings.MarketHours); err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if _, err := buildNotifiers(dir, payload.Settings.Channels, payload.Settings.Templates); err != nil {
+		respondJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid notification channels: %v", err))
+		return false
 	}
 
 	if err := writeJSONData(dir, normalizedRules); err != nil {
 		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed writing stocks.json: %v", err))
-		return
+		return false
 	}
 
 	if err := writeAppSettings(dir, payload.Settings); err != nil {
 		respondJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed writing settings file: %v", err))
-		return
+		return false
 	}
 
 	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	return true
+}
+
+// carryOverLockedSettings keeps the notification channels, the templates and
+// the holidays file as they are in the settings file. They run commands, send
+// requests with expanded environment variables, write files or read them, so
+// they can only be edited there. A payload may leave them out, in which case
+// the saved values are carried over; otherwise they must match what is saved.
+func carryOverLockedSettings(dir string, settings *AppSettings) error {
+	saved, err := readAppSettings(dir)
+	if err != nil {
+		return fmt.Errorf("failed reading settings file: %v", err)
+	}
+
+	if settings.Channels == nil {
+		settings.Channels = saved.Channels
+	} else if !sameJSON(settings.Channels, saved.Channels) {
+		return fmt.Errorf("notification channels cannot be added or changed from the web UI; edit %s instead", settingsFile)
+	}
+	if settings.Templates == nil {
+		settings.Templates = saved.Templates
+	} else if !sameJSON(settings.Templates, saved.Templates) {
+		return fmt.Errorf("message templates cannot be changed from the web UI; edit %s instead", settingsFile)
+	}
+
+	var savedHolidays string
+	if saved.MarketHours != nil {
+		savedHolidays = saved.MarketHours.HolidaysFile
+	}
+	switch {
+	case settings.MarketHours == nil:
+		settings.MarketHours = saved.MarketHours
+	case settings.MarketHours.HolidaysFile == "":
+		settings.MarketHours.HolidaysFile = savedHolidays
+	case settings.MarketHours.HolidaysFile != savedHolidays:
+		return fmt.Errorf("the holidays file cannot be changed from the web UI; edit %s instead", settingsFile)
+	}
+	return nil
+}
+
+// sameJSON reports whether a and b encode to the same JSON, counting empty
+// lists and missing values as the same.
+func sameJSON(a, b any) bool {
+	encode := func(value any) string {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		switch string(raw) {
+		case "null", "[]", "{}":
+			return ""
+		}
+		return string(raw)
+	}
+	return encode(a) == encode(b)
 }
 
-func handleCheckQuotes(dir string, w http.ResponseWriter) {
+func validateRuleValues(rule AlertRule) error {
+	switch {
+	case isBandDirection(rule.Direction):
+		if rule.Lower <= 0 {
+			return fmt.Errorf("invalid lower bound")
+		}
+	case rule.Direction == directionTrailingStop:
+		// Trail percent is validated by normalize.
+	case !isChangeDirection(rule.Direction):
+		if rule.Threshold <= 0 {
+			return fmt.Errorf("invalid threshold")
+		}
+	}
+	return nil
+}
+
+// handleCheckQuotes fetches every quote now. A running monitor that has
+// polled supplies the rules, state and market calendar it is using. Otherwise
+// they are read from dir, and without a monitor the saved settings are
+// applied first; a running monitor keeps the settings it validated.
+func handleCheckQuotes(dir string, w http.ResponseWriter, r *http.Request, monitor *Monitor) {
+	status := monitor.snapshot()
+	if status.rules != nil {
+		respondJSON(w, http.StatusOK, checkQuotes(r.Context(), status.rules, status.state, status.calendar))
+		return
+	}
+
 	settings, _ := readAppSettings(dir)
-	appSettings = settings
+	if monitor == nil {
+		setAppSettings(settings)
+	}
 
 	rules, err := readJSONData(dir)
 	if err != nil {
@@ -126,17 +417,14 @@ func handleCheckQuotes(dir string, w http.ResponseWriter) {
 		return
 	}
 
-	results := make([]quoteCheckResult, 0, len(rules))
-	for symbol := range rules {
-		price, err := GetStockPrice(symbol)
-		if err != nil {
-			results = append(results, quoteCheckResult{Symbol: symbol, Error: err.Error()})
-			continue
-		}
-		priceCopy := price
-		results = append(results, quoteCheckResult{Symbol: symbol, Price: &priceCopy})
+	calendar, err := newMarketCalendar(dir, settings.MarketHours)
+	if err != nil {
+		respondJSONError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
+	state, _ := readAlertState(dir)
+	results := checkQuotes(r.Context(), rules, state, calendar)
 	respondJSON(w, http.StatusOK, results)
 }
 
@@ -322,12 +610,13 @@ const webUIHTML = `<!doctype html>
   <main class="container">
   <h1>Stocks Notifier</h1>
   <div class="chip">Local Config UI</div>
-  <p class="muted">Changes are saved to <code>stocks.json</code> and <code>.stocks-notifier-settings.json</code>.</p>
+  <p class="muted">Changes are saved to <code>stocks.json</code> and <code>.stocks-notifier-settings.json</code> and picked up by the running monitor immediately.</p>
+  <p id="monitorStatus" class="muted"></p>
 
   <h2>Rules</h2>
   <table id="rulesTable">
     <thead>
-      <tr><th>Symbol</th><th>Threshold</th><th>Direction</th><th>Delete</th></tr>
+      <tr><th>Symbol</th><th>Rule ID</th><th>Threshold</th><th>Lower</th><th>Upper</th><th>Direction</th><th>Re-arm %</th><th>Trail %</th><th>Severity</th><th>Session</th><th>Bypass quiet hours</th><th>Status</th><th>Delete</th></tr>
     </thead>
     <tbody></tbody>
   </table>
@@ -340,6 +629,8 @@ const webUIHTML = `<!doctype html>
     <label><span>Poll interval</span><input id="pollInterval" placeholder="default 10m" /></label>
     <label><span>Near poll interval</span><input id="pollNearInterval" placeholder="default 2m" /></label>
     <label><span>Near threshold percent</span><input id="nearThresholdPercent" type="number" step="0.1" min="0" /></label>
+    <label><span>Re-arm percent</span><input id="rearmPercent" type="number" step="0.1" min="0" placeholder="default 0" /></label>
+    <label><span>Provider chain</span><input id="providers" placeholder="default stockprices.dev, stooq" /></label>
   </div>
 
   <div class="actions">
@@ -354,16 +645,51 @@ const webUIHTML = `<!doctype html>
     const tbody = document.querySelector("#rulesTable tbody");
     const statusEl = document.getElementById("status");
     const checkOutput = document.getElementById("checkOutput");
-
-    function addRuleRow(symbol = "", threshold = "", direction = "below") {
+    const monitorStatusEl = document.getElementById("monitorStatus");
+    // Settings without a form field (such as notification channels) are kept as loaded.
+    let loadedSettings = {};
+
+    const directions = [
+      ["below", "below"],
+      ["above", "above"],
+      ["change_pct_below", "day change % below"],
+      ["change_pct_above", "day change % above"],
+      ["between", "between lower/upper"],
+      ["outside", "outside lower/upper"],
+      ["trailing_stop", "trailing stop"]
+    ];
+    const severities = ["low", "default", "high", "urgent"];
+    const sessions = ["always", "regular", "extended"];
+
+    function addRuleRow(symbol = "", id = "", threshold = "", direction = "below", lower = "", upper = "", rearmPercent = "", trailPercent = "", severity = "default", session = "always", bypassQuietHours = false, key = "") {
       const tr = document.createElement("tr");
+      // Unnamed rules have no id to send back; key finds their status.
+      tr.dataset.ruleKey = key;
       tr.innerHTML =
         '<td><input data-key="symbol" value="' + symbol + '" /></td>' +
+        '<td><input data-key="id" placeholder="optional" value="' + id + '" /></td>' +
         '<td><input data-key="threshold" type="number" step="0.0001" value="' + threshold + '" /></td>' +
+        '<td><input data-key="lower" type="number" step="0.0001" value="' + lower + '" /></td>' +
+        '<td><input data-key="upper" type="number" step="0.0001" value="' + upper + '" /></td>' +
         '<td><select data-key="direction">' +
-          '<option value="below"' + (direction === "below" ? " selected" : "") + '>below</option>' +
-          '<option value="above"' + (direction === "above" ? " selected" : "") + '>above</option>' +
+          directions.map(([value, label]) =>
+            '<option value="' + value + '"' + (direction === value ? " selected" : "") + '>' + label + '</option>'
+          ).join("") +
+        '</select></td>' +
+        '<td><input data-key="rearmPercent" type="number" step="0.1" min="0" placeholder="default" value="' + rearmPercent + '" /></td>' +
+        '<td><input data-key="trailPercent" type="number" step="0.1" min="0" value="' + trailPercent + '" /></td>' +
+        '<td><select data-key="severity">' +
+          severities.map((value) =>
+            '<option value="' + value + '"' + (severity === value ? " selected" : "") + '>' + value + '</option>'
+          ).join("") +
         '</select></td>' +
+        '<td><select data-key="session">' +
+          sessions.map((value) =>
+            '<option value="' + value + '"' + (session === value ? " selected" : "") + '>' + value + '</option>'
+          ).join("") +
+        '</select></td>' +
+        '<td><input data-key="bypassQuietHours" type="checkbox"' + (bypassQuietHours ? " checked" : "") + ' /></td>' +
+        '<td data-key="status"></td>' +
         '<td><button type="button" data-action="delete">Delete</button></td>';
       tr.querySelector("[data-action='delete']").addEventListener("click", () => tr.remove());
       tbody.appendChild(tr);
@@ -383,39 +709,101 @@ const webUIHTML = `<!doctype html>
       }
 
       tbody.innerHTML = "";
-      Object.entries(data.rules || {}).forEach(([symbol, rule]) => {
-        addRuleRow(symbol, rule.threshold, rule.direction || "below");
+      Object.entries(data.rules || {}).forEach(([symbol, rules]) => {
+        const keys = (data.keys || {})[symbol] || [];
+        (Array.isArray(rules) ? rules : [rules]).forEach((rule, i) => {
+          addRuleRow(symbol, rule.id || "", rule.threshold || "", rule.direction || "below", rule.lower || "", rule.upper || "", rule.rearmPercent || "", rule.trailPercent || "", rule.severity || "default", rule.session || "always", !!rule.bypassQuietHours, keys[i] || "");
+        });
       });
       if (!Object.keys(data.rules || {}).length) addRuleRow();
 
       const s = data.settings || {};
+      loadedSettings = s;
       document.getElementById("allowDelayedFallback").checked = !!s.allowDelayedFallback;
       document.getElementById("reminderInterval").value = s.reminderInterval || "";
       document.getElementById("pollInterval").value = s.pollInterval || "";
       document.getElementById("pollNearInterval").value = s.pollNearInterval || "";
       document.getElementById("nearThresholdPercent").value = s.nearThresholdPercent || "";
+      document.getElementById("rearmPercent").value = s.rearmPercent || "";
+      await loadState();
+      document.getElementById("providers").value = (s.providers || []).join(", ");
       setStatus("Configuration loaded");
     }
 
+    async function loadState() {
+      const res = await fetch("/api/state");
+      if (!res.ok) return;
+      const state = await res.json();
+      [...tbody.querySelectorAll("tr")].forEach((tr) => {
+        const symbol = tr.querySelector("[data-key='symbol']").value.trim().toUpperCase();
+        const id = tr.querySelector("[data-key='id']").value.trim();
+        const status = state[id ? symbol + "#" + id : tr.dataset.ruleKey || symbol];
+        const cell = tr.querySelector("[data-key='status']");
+        if (!status) {
+          cell.textContent = "";
+        } else if (status.stopLevel) {
+          cell.textContent = "peak " + status.peakPrice.toFixed(2) + " / stop " + status.stopLevel.toFixed(2);
+        } else {
+          cell.textContent = status.held ? "held (quiet hours)" : status.inAlert ? "in alert" : "armed";
+        }
+      });
+    }
+
+    async function loadMonitorStatus() {
+      const res = await fetch("/api/status");
+      if (!res.ok) return;
+      const status = await res.json();
+      if (!status.running) {
+        monitorStatusEl.textContent = "Monitor not running in this process.";
+        return;
+      }
+      if (!status.lastPoll || status.lastPoll.startsWith("0001")) {
+        monitorStatusEl.textContent = "Monitor running, first poll in progress.";
+        return;
+      }
+      const errors = Object.entries(status.errors || {}).map(([symbol, message]) => symbol + ": " + message);
+      if (status.configError) errors.unshift(status.configError);
+      monitorStatusEl.textContent =
+        "Monitor last polled " + new Date(status.lastPoll).toLocaleTimeString() +
+        ", next poll " + new Date(status.nextPoll).toLocaleTimeString() + " (" + status.sleepReason + ")" +
+        (errors.length ? ". Errors: " + errors.join("; ") : "");
+    }
+
     function collectPayload() {
       const rules = {};
       [...tbody.querySelectorAll("tr")].forEach((tr) => {
         const symbol = tr.querySelector("[data-key='symbol']").value.trim().toUpperCase();
+        const id = tr.querySelector("[data-key='id']").value.trim();
         const thresholdRaw = tr.querySelector("[data-key='threshold']").value;
         const direction = tr.querySelector("[data-key='direction']").value;
         if (!symbol) return;
-        const threshold = parseFloat(thresholdRaw);
-        rules[symbol] = { threshold, direction };
+        const threshold = parseFloat(thresholdRaw) || 0;
+        const lower = parseFloat(tr.querySelector("[data-key='lower']").value) || 0;
+        const upper = parseFloat(tr.querySelector("[data-key='upper']").value) || 0;
+        const rearmPercent = parseFloat(tr.querySelector("[data-key='rearmPercent']").value) || 0;
+        const trailPercent = parseFloat(tr.querySelector("[data-key='trailPercent']").value) || 0;
+        const severity = tr.querySelector("[data-key='severity']").value;
+        const session = tr.querySelector("[data-key='session']").value;
+        const bypassQuietHours = tr.querySelector("[data-key='bypassQuietHours']").checked;
+        (rules[symbol] = rules[symbol] || []).push({
+          id, threshold, direction, lower, upper, rearmPercent, trailPercent,
+          severity: severity === "default" ? "" : severity,
+          session: session === "always" ? "" : session,
+          bypassQuietHours
+        });
       });
 
       return {
         rules,
         settings: {
+          ...loadedSettings,
           allowDelayedFallback: document.getElementById("allowDelayedFallback").checked,
           reminderInterval: document.getElementById("reminderInterval").value.trim(),
           pollInterval: document.getElementById("pollInterval").value.trim(),
           pollNearInterval: document.getElementById("pollNearInterval").value.trim(),
-          nearThresholdPercent: Number(document.getElementById("nearThresholdPercent").value) || 0
+          nearThresholdPercent: Number(document.getElementById("nearThresholdPercent").value) || 0,
+          rearmPercent: Number(document.getElementById("rearmPercent").value) || 0,
+          providers: document.getElementById("providers").value.split(",").map((p) => p.trim()).filter(Boolean)
         }
       };
     }
@@ -432,11 +820,15 @@ const webUIHTML = `<!doctype html>
         return;
       }
       setStatus("Configuration saved");
+      setTimeout(refreshLive, 2000);
     }
 
     async function checkQuotes() {
       checkOutput.textContent = "Checking...";
-      const res = await fetch("/api/check", { method: "POST" });
+      const res = await fetch("/api/check", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" }
+      });
       const data = await res.json();
       if (!res.ok) {
         setStatus(data.error || "Quote check failed", true);
@@ -451,7 +843,14 @@ const webUIHTML = `<!doctype html>
     document.getElementById("saveBtn").addEventListener("click", saveConfig);
     document.getElementById("checkBtn").addEventListener("click", checkQuotes);
 
+    async function refreshLive() {
+      await loadMonitorStatus();
+      await loadState();
+    }
+
     loadConfig();
+    loadMonitorStatus();
+    setInterval(refreshLive, 15000);
   </script>
 </body>
 </html>`
